@@ -0,0 +1,33 @@
+package api
+
+import (
+	"io"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// Backend is the set of backend operations the backup pipeline depends on.
+// APIClient is the HTTP implementation; tests and embedding programs can
+// supply their own.
+type Backend interface {
+	FindCompanyByAPIKey(apiKey string) (*models.Company, error)
+	GeneratePresignURL(apiKey, path string) (*models.PresignedUploadResponse, error)
+	UploadFile(apiKey string, filePath string) error
+	UploadFileTo(apiKey, folderPrefix, filePath string) error
+	RotateFileTo(apiKey, folderPrefix, filePath string) error
+	DownloadFile(apiKey, key string, w io.Writer) error
+	InsertFileMetadata(meta *models.FileMetadata) error
+	UpdateCompanyQuota(usageQuota *models.UpdateUsageQuota) error
+	GetFolderSize(apiKey, folderPrefix string) (*models.FolderInfoResponse, error)
+	DeleteFiles(apiKey, folderPrefix string) error
+	DeleteFileKeys(apiKey, folderPrefix string, keys []string) error
+	ListFiles(apiKey, folderPrefix string) ([]models.RemoteFile, error)
+	TrashFiles(apiKey, folderPrefix string, keys []string) error
+	ListTrash(apiKey, folderPrefix string) ([]models.RemoteFile, error)
+	RestoreFiles(apiKey, folderPrefix string, keys []string) error
+	PurgeTrash(apiKey, folderPrefix string, keys []string) error
+	SetProtected(apiKey, folderPrefix string, keys []string, protected bool) error
+	InsertRunSummary(summary *models.RunSummary) error
+}
+
+var _ Backend = (*APIClient)(nil)
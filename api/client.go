@@ -3,17 +3,21 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 
+	"github.com/google/uuid"
 	"shreshtasmg.in/sh_backups/config"
 	"shreshtasmg.in/sh_backups/logger"
 	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/runid"
 )
 
 type APIClient struct {
@@ -34,10 +38,11 @@ const (
 	locTag = "TallyBackups"
 )
 
+// DeleteFiles deletes every object under folderPrefix.
 func (c *APIClient) DeleteFiles(apiKey, folderPrefix string) error {
 	url := fmt.Sprintf("%s/api/companies/delete/files", c.BaseURL)
 	deleteReq := &models.FileDeleteRequest{
-		LocTag: locTag,
+		LocTag: folderPrefix,
 	}
 	body, err := json.Marshal(deleteReq)
 	if err != nil {
@@ -50,55 +55,210 @@ func (c *APIClient) DeleteFiles(apiKey, folderPrefix string) error {
 		return err
 	}
 	req.Header.Set("X-Company-Api-Key", apiKey)
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when deleting files", err)
+		return unexpectedStatus(resp, "Unexpected status when deleting files")
+	}
+	logger.Info("Deleted files successfully", "folder", folderPrefix)
+	return nil
+}
+
+// DeleteFileKeys deletes only the given object keys under folderPrefix.
+// It has its own endpoint, so a backend that does not know it fails the
+// request rather than deleting the whole folder.
+func (c *APIClient) DeleteFileKeys(apiKey, folderPrefix string, keys []string) error {
+	if len(keys) == 0 {
+		return errors.New("no file keys to delete")
+	}
+	url := fmt.Sprintf("%s/api/companies/delete/file/keys", c.BaseURL)
+	deleteReq := &models.FileDeleteRequest{
+		LocTag:   folderPrefix,
+		FileKeys: keys,
+	}
+	body, err := json.Marshal(deleteReq)
+	if err != nil {
+		logger.Error("Failed to marshal delete request", err)
 		return err
 	}
-	logger.Info("Deleted files " + folderPrefix + " successfully")
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		logger.Error("Failed to create new HTTP request", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Company-Api-Key", apiKey)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp, "Unexpected status when deleting files")
+	}
+	logger.Info("Deleted files successfully", "folder", folderPrefix, "files", len(keys))
 	return nil
 }
 
-func (c *APIClient) GetFolderSize(apiKey, folderPrefix string) (*models.FolderInfoResponse, error) {
-	url := fmt.Sprintf("%s/api/filemeta/folder/size", c.BaseURL)
-	req, err := http.NewRequest("GET", url, nil)
+// TrashFiles moves the given object keys under folderPrefix to the
+// company's trash, from where they can be restored until purged. It fails
+// unless the backend confirms that every key was trashed.
+func (c *APIClient) TrashFiles(apiKey, folderPrefix string, keys []string) error {
+	if len(keys) == 0 {
+		return errors.New("no file keys to trash")
+	}
+	body, err := json.Marshal(&models.FileDeleteRequest{LocTag: folderPrefix, FileKeys: keys})
+	if err != nil {
+		logger.Error("Failed to marshal trash request", err)
+		return err
+	}
+	req, err := http.NewRequest("POST", c.BaseURL+"/api/companies/trash/files", bytes.NewBuffer(body))
+	if err != nil {
+		logger.Error("Failed to create new HTTP request", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Company-Api-Key", apiKey)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp, "Unexpected status when moving files to trash")
+	}
+	var trashed models.TrashResponse
+	if err := json.NewDecoder(resp.Body).Decode(&trashed); err != nil {
+		logger.Error("Failed to decode trash response", err)
+		return err
+	}
+	moved := make(map[string]bool, len(trashed.Trashed))
+	for _, key := range trashed.Trashed {
+		moved[key] = true
+	}
+	for _, key := range keys {
+		if !moved[key] {
+			return fmt.Errorf("backend did not confirm %s was moved to trash", key)
+		}
+	}
+	logger.Info("Moved files to trash successfully", "folder", folderPrefix, "files", len(keys))
+	return nil
+}
+
+// ListTrash returns the trashed backups of folderPrefix. LastModified is
+// the time each one was trashed.
+func (c *APIClient) ListTrash(apiKey, folderPrefix string) ([]models.RemoteFile, error) {
+	return c.listFolder(apiKey, "/api/filemeta/trash/list", folderPrefix)
+}
+
+// RestoreFiles moves trashed object keys back under folderPrefix.
+func (c *APIClient) RestoreFiles(apiKey, folderPrefix string, keys []string) error {
+	req := &models.FileDeleteRequest{LocTag: folderPrefix, FileKeys: keys}
+	return c.postJSON(apiKey, "/api/companies/trash/restore", req, "restoring files from trash")
+}
+
+// PurgeTrash permanently deletes trashed object keys.
+func (c *APIClient) PurgeTrash(apiKey, folderPrefix string, keys []string) error {
+	req := &models.FileDeleteRequest{LocTag: folderPrefix, FileKeys: keys}
+	return c.postJSON(apiKey, "/api/companies/trash/purge", req, "purging trash")
+}
+
+// SetProtected sets or clears the protected (legal hold) flag of the given
+// object keys.
+func (c *APIClient) SetProtected(apiKey, folderPrefix string, keys []string, protected bool) error {
+	req := &models.ProtectRequest{LocTag: folderPrefix, FileKeys: keys, Protected: protected}
+	return c.postJSON(apiKey, "/api/filemeta/protect", req, "changing protection")
+}
+
+// postJSON posts v as JSON to path and expects a 200 or 201 response.
+func (c *APIClient) postJSON(apiKey, path string, v any, action string) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("Failed to marshal request body", err, "path", path)
+		return err
+	}
+	req, err := http.NewRequest("POST", c.BaseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		logger.Error("Failed to create new HTTP request", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Company-Api-Key", apiKey)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return unexpectedStatus(resp, "Unexpected status when "+action)
+	}
+	return nil
+}
+
+// ListFiles returns the backups stored under folderPrefix.
+func (c *APIClient) ListFiles(apiKey, folderPrefix string) ([]models.RemoteFile, error) {
+	return c.listFolder(apiKey, "/api/filemeta/folder/list", folderPrefix)
+}
+
+func (c *APIClient) listFolder(apiKey, path, folderPrefix string) ([]models.RemoteFile, error) {
+	endpoint := fmt.Sprintf("%s%s?%s", c.BaseURL, path, url.Values{"loc_tag": {folderPrefix}}.Encode())
+	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		logger.Error("Failed to create new HTTP request", err)
 		return nil, err
 	}
 	req.Header.Set("X-Company-Api-Key", apiKey)
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when getting folder size", err)
+		return nil, unexpectedStatus(resp, "Unexpected status when listing files")
+	}
+	var listing models.FolderListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		logger.Error("Failed to decode folder listing", err)
+		return nil, err
+	}
+	logger.Debug("Listed folder successfully", "folder", folderPrefix, "files", len(listing.Files))
+	return listing.Files, nil
+}
+
+func (c *APIClient) GetFolderSize(apiKey, folderPrefix string) (*models.FolderInfoResponse, error) {
+	endpoint := fmt.Sprintf("%s/api/filemeta/folder/size?%s", c.BaseURL, url.Values{"loc_tag": {folderPrefix}}.Encode())
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		logger.Error("Failed to create new HTTP request", err)
+		return nil, err
+	}
+	req.Header.Set("X-Company-Api-Key", apiKey)
+	resp, err := c.do(req)
+	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedStatus(resp, "Unexpected status when getting folder size")
+	}
 	var folderSize *models.FolderInfoResponse
 	err = json.NewDecoder(resp.Body).Decode(&folderSize)
 	if err != nil {
 		logger.Error("Failed to decode folder size response", err)
 		return nil, err
 	}
-	logger.Info("Got folder size " + folderPrefix + " successfully")
+	logger.Debug("Got folder size successfully", "folder", folderPrefix, "size", folderSize.TotalSize)
 	return folderSize, nil
 }
 
 func (c *APIClient) GeneratePresignURL(apiKey, path string) (*models.PresignedUploadResponse, error) {
+	return c.presignUpload(apiKey, locTag, path, false)
+}
+
+func (c *APIClient) presignUpload(apiKey, folderPrefix, path string, rotate bool) (*models.PresignedUploadResponse, error) {
 	// Write Request Presign
 	url := fmt.Sprintf("%s/api/companies/generate/presigned/url/upload", c.BaseURL)
 	filePathWithExt := filepath.Base(path)
@@ -111,7 +271,8 @@ func (c *APIClient) GeneratePresignURL(apiKey, path string) (*models.PresignedUp
 	presignReq := &models.PresignUploadRequest{
 		FileName:    filePathWithExt,
 		ContentSize: fileSize,
-		LocTag:      locTag,
+		LocTag:      folderPrefix,
+		Rotate:      rotate,
 	}
 	body, err := json.Marshal(presignReq)
 	if err != nil {
@@ -124,18 +285,15 @@ func (c *APIClient) GeneratePresignURL(apiKey, path string) (*models.PresignedUp
 		return nil, err
 	}
 	req.Header.Set("X-Company-Api-Key", c.APIKey)
-	resp, err := c.Client.Do(req)
+	// Presigning only signs a URL, so it can be repeated.
+	req.Header.Set(IdempotencyKeyHeader, uuid.NewString())
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when fetching presign upload url", err)
-		return nil, err
+		return nil, unexpectedStatus(resp, "Unexpected status when fetching presign upload url")
 	}
 	// Create an instance of our struct.
 	var presignedResponse *models.PresignedUploadResponse
@@ -149,7 +307,24 @@ func (c *APIClient) GeneratePresignURL(apiKey, path string) (*models.PresignedUp
 }
 
 func (c *APIClient) UploadFile(apiKey string, filePath string) error {
-	requestPresignUpload, err := c.GeneratePresignURL(apiKey, filePath)
+	return c.UploadFileTo(apiKey, locTag, filePath)
+}
+
+// UploadFileTo uploads filePath under folderPrefix instead of the default
+// backup folder.
+func (c *APIClient) UploadFileTo(apiKey, folderPrefix, filePath string) error {
+	return c.uploadFile(apiKey, folderPrefix, filePath, false)
+}
+
+// RotateFileTo uploads filePath under folderPrefix for a rotation. The
+// backend lets it take usage past the quota, since the rotation deletes the
+// backups it replaces right after.
+func (c *APIClient) RotateFileTo(apiKey, folderPrefix, filePath string) error {
+	return c.uploadFile(apiKey, folderPrefix, filePath, true)
+}
+
+func (c *APIClient) uploadFile(apiKey, folderPrefix, filePath string, rotate bool) error {
+	requestPresignUpload, err := c.presignUpload(apiKey, folderPrefix, filePath, rotate)
 	if err != nil {
 		return err
 	}
@@ -215,20 +390,66 @@ func (c *APIClient) UploadFile(apiKey string, filePath string) error {
 	}
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("X-Company-Api-Key", c.APIKey)
-	resp, err := c.Client.Do(req)
+	// Uploading the same object again just replaces it.
+	req.Header.Set(IdempotencyKeyHeader, requestPresignUpload.Fields["key"])
+	resp, err := c.do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		respBody, _ := io.ReadAll(resp.Body)
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when uploading file", err)
+		return unexpectedStatus(resp, "Unexpected status when uploading file")
+	}
+
+	return nil
+}
+
+// DownloadFile writes the object stored at key to w, through a presigned
+// GET URL.
+func (c *APIClient) DownloadFile(apiKey, key string, w io.Writer) error {
+	url := fmt.Sprintf("%s/api/companies/generate/presigned/url/download", c.BaseURL)
+	body, err := json.Marshal(&models.PresignDownloadRequest{FileKey: key})
+	if err != nil {
+		logger.Error("Failed to marshal presign download request", err)
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		logger.Error("Failed to create new HTTP request", err)
+		return err
+	}
+	req.Header.Set("X-Company-Api-Key", apiKey)
+	req.Header.Set(IdempotencyKeyHeader, uuid.NewString())
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp, "Unexpected status when fetching presign download url")
+	}
+	var presigned models.PresignedDownloadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&presigned); err != nil {
+		logger.Error("Failed to decode presign download response", err)
 		return err
 	}
 
+	getReq, err := http.NewRequest("GET", presigned.URL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request: %w", err)
+	}
+	getResp, err := c.do(getReq)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer getResp.Body.Close()
+	if getResp.StatusCode != http.StatusOK {
+		return unexpectedStatus(getResp, "Unexpected status when downloading file")
+	}
+	if _, err := io.Copy(w, getResp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	logger.Debug("Downloaded file successfully", "key", key)
 	return nil
 }
 
@@ -241,22 +462,18 @@ func (c *APIClient) FindCompanyByAPIKey(apiKey string) (*models.Company, error)
 		return nil, err
 	}
 	req.Header.Set("X-Company-Api-Key", c.APIKey)
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when fetching company by API key", err)
-		return nil, err
+		return nil, unexpectedStatus(resp, "Unexpected status when fetching company by API key")
 	}
 	var company models.Company
 	respBody, _ := io.ReadAll(resp.Body)
 	if err := json.Unmarshal(respBody, &company); err != nil {
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		logger.Error("Failed to decode company response", err)
+		logger.Error("Failed to decode company response", err, "detail", config.ParseErrorBody(resp.Status, respBody))
 		return nil, err
 	}
 	return &company, nil
@@ -277,18 +494,15 @@ func (c *APIClient) InsertFileMetadata(meta *models.FileMetadata) error {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Company-Api-Key", c.APIKey)
-	resp, err := c.Client.Do(req)
+	// The backend ignores a record whose ID it already has.
+	req.Header.Set(IdempotencyKeyHeader, meta.Id)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when inserting file metadata", err)
-		return err
+		return unexpectedStatus(resp, "Unexpected status when inserting file metadata")
 	}
 	return nil
 }
@@ -308,18 +522,64 @@ func (c *APIClient) UpdateCompanyQuota(usageQuota *models.UpdateUsageQuota) erro
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Company-Api-Key", c.APIKey)
-	resp, err := c.Client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		err1 := config.ParseErrorBody(resp.Status, respBody)
-		logger.ErrorFn(err1)
-		err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
-		logger.Error("Unexpected status when updating company quota", err)
+		return unexpectedStatus(resp, "Unexpected status when updating company quota")
+	}
+	return nil
+}
+
+// InsertRunSummary reports the summary of a finished run.
+func (c *APIClient) InsertRunSummary(summary *models.RunSummary) error {
+	url := fmt.Sprintf("%s/api/runs", c.BaseURL)
+	body, err := json.Marshal(summary)
+	if err != nil {
+		logger.Error("Failed to marshal run summary", err)
 		return err
 	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		logger.Error("Failed to create new HTTP request for inserting run summary", err)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Company-Api-Key", c.APIKey)
+	// The backend keeps one summary per run ID.
+	req.Header.Set(IdempotencyKeyHeader, summary.RunId)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp, "Unexpected status when inserting run summary")
+	}
 	return nil
 }
+
+// do sends req with the correlation headers of the current run, so the
+// backend can log the same IDs.
+func (c *APIClient) do(req *http.Request) (*http.Response, error) {
+	if ids := runid.Current(); ids.Run != "" {
+		req.Header.Set("X-Request-Id", ids.Run)
+		req.Header.Set("Traceparent", ids.Traceparent())
+	}
+	return c.Client.Do(req)
+}
+
+// unexpectedStatus logs a non-success response, including the backend's
+// error detail and request ID, and returns an error describing it.
+func unexpectedStatus(resp *http.Response, msg string) error {
+	respBody, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	fields := []any{"detail", config.ParseErrorBody(resp.Status, respBody)}
+	if requestID := resp.Header.Get("X-Request-Id"); requestID != "" {
+		fields = append(fields, "request_id", requestID)
+	}
+	logger.Error(msg, err, fields...)
+	return err
+}
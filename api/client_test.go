@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/runid"
+)
+
+// Every request carries the run ID and a traceparent naming the current
+// operation, so the backend can log the same IDs.
+func TestRequestsCarryRunIDs(t *testing.T) {
+	var got []http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Clone())
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	c := NewAPIClient(ts.URL, "key")
+
+	if _, err := c.FindCompanyByAPIKey("key"); err != nil {
+		t.Fatal(err)
+	}
+	run := runid.StartRun()
+	t.Cleanup(runid.EndRun)
+	op := runid.StartOperation()
+	if _, err := c.FindCompanyByAPIKey("key"); err != nil {
+		t.Fatal(err)
+	}
+	runid.EndOperation()
+	if _, err := c.FindCompanyByAPIKey("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("server saw %d requests, want 3", len(got))
+	}
+	if h := got[0]; h.Get("X-Request-Id") != "" || h.Get("Traceparent") != "" {
+		t.Errorf("request outside a run carried IDs: %v", h)
+	}
+	if h := got[1]; h.Get("X-Request-Id") != run || h.Get("Traceparent") != "00-"+run+"-"+op+"-01" {
+		t.Errorf("request in an operation: X-Request-Id %q, Traceparent %q", h.Get("X-Request-Id"), h.Get("Traceparent"))
+	}
+	if h := got[2]; h.Get("X-Request-Id") != run || h.Get("Traceparent") != runid.Current().Traceparent() {
+		t.Errorf("request between operations: X-Request-Id %q, Traceparent %q", h.Get("X-Request-Id"), h.Get("Traceparent"))
+	}
+}
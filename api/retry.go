@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/logger"
+)
+
+// IdempotencyKeyHeader marks a request that is safe to repeat even though
+// its method is not idempotent, such as a POST the server deduplicates by
+// this key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// retryTransport retries requests that fail with a network error or a
+// transient status. Only idempotent requests are retried: a repeated POST
+// or PATCH, such as a quota update, could be applied twice. Requests whose
+// body cannot be replayed are sent once.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries config.RetryConfig
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.retries.InitialBackoff.Duration
+	current := req
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(current)
+		if attempt >= t.retries.MaxAttempts || !idempotent(req) || !retryable(resp, err) {
+			return resp, err
+		}
+		next, ok := rewind(req)
+		if !ok {
+			return resp, err
+		}
+		fields := []any{"method", req.Method, "url", req.URL.Redacted(), "attempt", attempt, "max_attempts", t.retries.MaxAttempts, "backoff", backoff}
+		if err != nil {
+			logger.Warn("Request failed, retrying", append(fields, "error", err)...)
+		} else {
+			logger.Warn("Request returned a transient status, retrying", append(fields, "status", resp.StatusCode)...)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, t.retries.MaxBackoff.Duration)
+		current = next
+	}
+}
+
+// idempotent reports whether sending req more than once has the same
+// effect as sending it once.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewind returns a copy of req with a fresh body for another attempt.
+func rewind(req *http.Request) (*http.Request, bool) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	next.Body = body
+	return next, true
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+)
+
+// traceBodyLimit is how much of each request and response body is logged.
+const traceBodyLimit = 2048
+
+// sensitiveHeaders are masked before headers are logged; logger.Redact
+// additionally scrubs every record.
+var sensitiveHeaders = map[string]bool{
+	"X-Company-Api-Key":   true,
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// traceTransport logs every exchange at debug level: method, URL, status,
+// connection timings, headers and the start of both bodies.
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		start                              = time.Now()
+		dnsStart, connectStart, tlsStart   time.Time
+		dns, connect, tlsHandshake, toByte time.Duration
+		reused                             bool
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn:              func(info httptrace.GotConnInfo) { reused = info.Reused },
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { dns = time.Since(dnsStart) },
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { connect = time.Since(connectStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { tlsHandshake = time.Since(tlsStart) },
+		GotFirstResponseByte: func() { toByte = time.Since(start) },
+	}
+	traced := req.Clone(httptrace.WithClientTrace(req.Context(), trace))
+
+	logger.Debug("HTTP request",
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"headers", formatHeaders(req.Header),
+		"body", requestBody(req))
+
+	resp, err := t.next.RoundTrip(traced)
+	timings := []any{
+		"duration", time.Since(start),
+		"dns", dns,
+		"connect", connect,
+		"tls", tlsHandshake,
+		"first_byte", toByte,
+		"reused_conn", reused,
+	}
+	if err != nil {
+		logger.Debug("HTTP request failed", append([]any{"method", req.Method, "url", req.URL.Redacted(), "error", err}, timings...)...)
+		return nil, err
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, traceBodyLimit+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	// Hand the caller the full body: the part already read plus the rest.
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+
+	logger.Debug("HTTP response", append([]any{
+		"method", req.Method,
+		"url", req.URL.Redacted(),
+		"status", resp.StatusCode,
+		"headers", formatHeaders(resp.Header),
+		"body", truncateBody(head),
+	}, timings...)...)
+	return resp, nil
+}
+
+// requestBody returns the start of the request body without consuming it.
+func requestBody(req *http.Request) string {
+	if req.Body == nil || req.Body == http.NoBody {
+		return ""
+	}
+	if req.GetBody == nil {
+		return "(body not replayable)"
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return fmt.Sprintf("(unreadable body: %v)", err)
+	}
+	defer body.Close()
+	head, _ := io.ReadAll(io.LimitReader(body, traceBodyLimit+1))
+	return truncateBody(head)
+}
+
+func truncateBody(b []byte) string {
+	suffix := ""
+	if len(b) > traceBodyLimit {
+		b, suffix = b[:traceBodyLimit], "...(truncated)"
+	}
+	return strings.ToValidUTF8(string(b), "?") + suffix
+}
+
+// formatHeaders renders headers sorted by name, masking credentials.
+func formatHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[REDACTED]"
+		}
+		parts = append(parts, name+": "+value)
+	}
+	return strings.Join(parts, "; ")
+}
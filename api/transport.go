@@ -0,0 +1,129 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"shreshtasmg.in/sh_backups/config"
+)
+
+// NewAPIClientWithNetwork is like NewAPIClient but routes every request,
+// including the presigned S3 upload, through the given proxy and TLS settings.
+func NewAPIClientWithNetwork(baseURL, apiKey string, netCfg config.NetworkConfig) (*APIClient, error) {
+	client, err := NewHTTPClient(netCfg)
+	if err != nil {
+		return nil, err
+	}
+	c := NewAPIClient(baseURL, apiKey)
+	c.Client = client
+	return c, nil
+}
+
+// NewHTTPClient builds an http.Client honouring the proxy, CA bundle,
+// client certificate and minimum TLS version in netCfg.
+func NewHTTPClient(netCfg config.NetworkConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy, err := proxyFunc(netCfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxy
+
+	tlsConfig, err := tlsConfig(netCfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func proxyFunc(netCfg config.NetworkConfig) (func(*http.Request) (*url.URL, error), error) {
+	if netCfg.ProxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := url.Parse(netCfg.ProxyURL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q", netCfg.ProxyURL)
+	}
+	if netCfg.ProxyUsername != "" {
+		proxyURL.User = url.UserPassword(netCfg.ProxyUsername, netCfg.ProxyPassword)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
+
+func tlsConfig(netCfg config.NetworkConfig) (*tls.Config, error) {
+	cfg := &tls.Config{}
+
+	if netCfg.MinTLSVersion != "" {
+		version, err := parseTLSVersion(netCfg.MinTLSVersion)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MinVersion = version
+	}
+
+	if netCfg.CACertFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(netCfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", netCfg.CACertFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if netCfg.ClientCertFile != "" || netCfg.ClientKeyFile != "" {
+		if netCfg.ClientCertFile == "" || netCfg.ClientKeyFile == "" {
+			return nil, errors.New("client certificate and key must be configured together")
+		}
+		cert, err := tls.LoadX509KeyPair(netCfg.ClientCertFile, netCfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported minimum TLS version %q (use 1.0, 1.1, 1.2 or 1.3)", v)
+}
+
+// NewAPIClientFromConfig builds the client for a loaded configuration,
+// applying its network settings, HTTP trace and retry policy. Each retry
+// attempt is traced separately.
+func NewAPIClientFromConfig(cfg config.AppConfig) (*APIClient, error) {
+	c, err := NewAPIClientWithNetwork(cfg.APIBaseUrl, cfg.APIKey, cfg.Network)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Logging.HTTPTraceEnabled() {
+		c.Client.Transport = &traceTransport{next: c.Client.Transport}
+	}
+	if cfg.Retries.MaxAttempts > 1 {
+		c.Client.Transport = &retryTransport{next: c.Client.Transport, retries: cfg.Retries}
+	}
+	return c, nil
+}
@@ -0,0 +1,121 @@
+// Package backup implements the upload and delete flows of sh_backups on top
+// of an api.Backend, so they can be embedded in other programs or driven
+// against a fake backend.
+package backup
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/ledger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/runid"
+	"shreshtasmg.in/sh_backups/storage"
+)
+
+const (
+	// LocTag is the folder under the company prefix that holds Tally backups.
+	LocTag = "TallyBackups"
+)
+
+// Pipeline runs backup operations for a single company.
+type Pipeline struct {
+	Backend api.Backend
+	Company *models.Company
+	LocTag  string
+	// Job names the configured job in log records.
+	Job string
+	// Retention, when set, makes Delete prune by policy instead of emptying
+	// the folder at quota.
+	Retention Retention
+	// Safeguards restrict what may be deleted.
+	Safeguards Safeguards
+	// Confirm, when set, is asked before Delete empties the folder, with the
+	// backups about to go; nothing is deleted unless it returns true.
+	Confirm func(files []models.RemoteFile) bool
+	// Destinations are where each upload is copied after the backend, e.g.
+	// a second cloud or a NAS, for a 3-2-1 backup.
+	Destinations []storage.Destination
+	// Mirror, when set, keeps a local copy of every uploaded backup.
+	Mirror *Mirror
+	// StagingDir, when set, is where the chosen backup is copied before it
+	// is checked and uploaded, so a backup rewritten mid-upload cannot
+	// reach S3.
+	StagingDir string
+	// Chunked stores uploads as content-defined chunks under ChunkLocTag,
+	// uploading only the chunks not already stored, with a manifest in
+	// the backup folder standing for each backup.
+	Chunked bool
+	// Version is the sh_backups version recorded in backup manifests.
+	Version string
+	// Ledger, when set, records the backups stored and deleted so Reconcile
+	// can compare it with the remote folder.
+	Ledger *ledger.Ledger
+	// Stats accumulates what Upload and Delete did, for the run summary.
+	Stats Stats
+}
+
+// Stats counts the files and bytes handled by a pipeline.
+type Stats struct {
+	FilesConsidered int
+	FilesUploaded   int
+	FilesSkipped    int
+	FilesDeleted    int
+	BytesUploaded   int64
+	BytesDeleted    int64
+	FilesMirrored   int
+	BytesMirrored   int64
+	FilesVerified   int
+}
+
+func New(backend api.Backend, company *models.Company) *Pipeline {
+	return &Pipeline{
+		Backend: backend,
+		Company: company,
+		LocTag:  LocTag,
+		Job:     "default",
+	}
+}
+
+// txnMeta appends the current run and operation IDs to a transaction
+// description, so metadata rows can be traced back to the run's logs.
+func txnMeta(msg string) string {
+	if ids := runid.Current(); ids.Run != "" {
+		return msg + " (" + ids.String() + ")"
+	}
+	return msg
+}
+
+// newMeta returns a file metadata record of type txn for the file name
+// stored at key, stamped with this host and the current run.
+func (p *Pipeline) newMeta(txn models.FileTxnType, name, key string, size int64, msg string) *models.FileMetadata {
+	host, _ := os.Hostname()
+	return &models.FileMetadata{
+		Id:          uuid.NewString(),
+		CreatedAt:   time.Now().Format(time.RFC3339),
+		FileName:    name,
+		FileSize:    &size,
+		FileKey:     key,
+		CompanyId:   p.Company.Id,
+		FileTxnType: txn.Ptr(),
+		FileTxnMeta: txnMeta(msg),
+		Host:        host,
+		RunId:       runid.Current().Run,
+	}
+}
+
+// ErrQuotaReached is returned when the company has used its whole quota.
+var ErrQuotaReached = errors.New("company has reached its usage quota")
+
+// CheckQuota reports ErrQuotaReached once the used quota meets the total.
+func (p *Pipeline) CheckQuota() error {
+	c := p.Company
+	if c.TotalUsageQuota != nil && c.UsedQuota != nil && *c.UsedQuota >= *c.TotalUsageQuota {
+		return ErrQuotaReached
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/mockserver"
+	"shreshtasmg.in/sh_backups/mockserver/mockservertest"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+const testAPIKey = mockservertest.APIKey
+
+// fixture is a pipeline wired to an in-process mock backend, with a local
+// folder of Tally backups to upload.
+type fixture struct {
+	t      *testing.T
+	srv    *mockservertest.Backend
+	client *api.APIClient
+	p      *Pipeline
+	local  string
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	srv := mockservertest.New(t, mockserver.Options{})
+	company, err := srv.Client.FindCompanyByAPIKey(testAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{t: t, srv: srv, client: srv.Client, p: New(srv.Client, company), local: t.TempDir()}
+}
+
+// backup writes a Tally backup dated day (YYYY-MM-DD) to the local folder,
+// holding content, and returns its path.
+func (f *fixture) backup(day string, content []byte) string {
+	f.t.Helper()
+	date, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	path := filepath.Join(f.local, "Tallybackupason"+date.Format("02012006")+".zip")
+	out, err := os.Create(path)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	zw := zip.NewWriter(out)
+	// Stored rather than deflated, so chunked tests control the bytes.
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "Company/data.900", Method: zip.Store})
+	if err == nil {
+		_, err = w.Write(content)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return path
+}
+
+// upload writes a backup dated day and uploads it, as the newest local
+// backup.
+func (f *fixture) upload(day string) {
+	f.t.Helper()
+	f.backup(day, []byte("tally data of "+day))
+	if err := f.p.Upload(f.local); err != nil {
+		f.t.Fatalf("Upload %s: %v", day, err)
+	}
+}
+
+// remote lists the names in the backup folder.
+func (f *fixture) remote() []string {
+	f.t.Helper()
+	files, err := f.client.ListFiles(testAPIKey, f.p.LocTag)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	list := names(files)
+	slices.Sort(list)
+	return list
+}
+
+// setQuota sets the company's total quota on the backend and refetches the
+// company, as the next run would.
+func (f *fixture) setQuota(quota int64) {
+	f.t.Helper()
+	f.srv.SetTotalUsageQuota(quota)
+	company, err := f.client.FindCompanyByAPIKey(testAPIKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.p.Company = company
+}
+
+// usedQuota is the used quota the mock backend has recorded.
+func (f *fixture) usedQuota() int64 {
+	return *f.srv.Company().UsedQuota
+}
+
+// folderSize is what the backup and chunk folders really hold.
+func (f *fixture) folderSize() int64 {
+	f.t.Helper()
+	var total int64
+	for _, folder := range []string{f.p.LocTag, ChunkLocTag} {
+		info, err := f.client.GetFolderSize(testAPIKey, folder)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		total += info.TotalSize
+	}
+	return total
+}
+
+// transactions returns the metadata records of type txn.
+func (f *fixture) transactions(txn models.FileTxnType) []models.FileMetadata {
+	var out []models.FileMetadata
+	for _, m := range f.srv.FileMetadata() {
+		if m.FileTxnType != nil && *m.FileTxnType == txn {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func names(files []models.RemoteFile) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, f.FileName)
+	}
+	return out
+}
+
+func backupName(day string) string {
+	date, _ := time.Parse(time.DateOnly, day)
+	return "Tallybackupason" + date.Format("02012006") + ".zip"
+}
+
+// withManifests returns the remote names of the backups dated days with
+// their manifests, sorted like fixture.remote.
+func withManifests(days ...string) []string {
+	var out []string
+	for _, day := range days {
+		out = append(out, backupName(day), backupName(day)+ManifestSuffix)
+	}
+	slices.Sort(out)
+	return out
+}
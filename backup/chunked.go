@@ -0,0 +1,348 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+const (
+	// ChunkLocTag is the folder under the company prefix that holds the
+	// chunks of chunked backups, each named by its SHA-256.
+	ChunkLocTag = "TallyChunks"
+	// ChunkManifestSuffix is appended to a backup's name to name the
+	// manifest that stands for it in the backup folder.
+	ChunkManifestSuffix = ".chunks.json"
+	// chunkGrace keeps chunks stored this recently from being collected,
+	// since an upload still in progress has not written its manifest yet.
+	chunkGrace = time.Hour
+)
+
+// ErrChunkedRotate is returned by Rotate for a chunked pipeline: the space
+// freed by removing a chunked backup is not known until its chunks are
+// collected.
+var ErrChunkedRotate = errors.New("rotate is not supported for chunked backups; use delete with a retention policy")
+
+// ChunkManifest lists the chunks a chunked backup is reassembled from, in
+// order.
+type ChunkManifest struct {
+	Version   int     `json:"version"`
+	FileName  string  `json:"file_name"`
+	Size      int64   `json:"size"`
+	SHA256    string  `json:"sha256"`
+	CreatedAt string  `json:"created_at"`
+	Chunks    []Chunk `json:"chunks"`
+}
+
+// Chunk is one piece of a chunked backup.
+type Chunk struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// IsChunked reports whether a remote file name is a chunk manifest.
+func IsChunked(name string) bool {
+	return strings.HasSuffix(name, ChunkManifestSuffix)
+}
+
+// uploadChunked splits localZipPath into content-defined chunks, uploads
+// the chunks not already stored, then the manifest, and records the upload
+// transaction. It returns the bytes newly stored, which is what the quota
+// is charged.
+func (p *Pipeline) uploadChunked(localZipPath string) (int64, error) {
+	start := time.Now()
+	name := filepath.Base(localZipPath)
+	apiKey := p.Company.CompanyApiKey
+	stored, err := p.Backend.ListFiles(apiKey, ChunkLocTag)
+	if err != nil {
+		logger.Error("Cannot list stored chunks", err, "job", p.Job, "folder", ChunkLocTag)
+		return 0, err
+	}
+	have := make(map[string]bool, len(stored))
+	for _, f := range stored {
+		have[f.FileName] = true
+	}
+
+	dir, err := p.tempDir("chunks-")
+	if err != nil {
+		return 0, fmt.Errorf("cannot create chunk directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Open(localZipPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	whole := sha256.New()
+	c := newChunker(io.TeeReader(f, whole))
+	manifest := ChunkManifest{Version: 1, FileName: name, CreatedAt: time.Now().Format(time.RFC3339)}
+	var added int64
+	var addedChunks int
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		sum := sha256.Sum256(data)
+		chunk := Chunk{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+		manifest.Chunks = append(manifest.Chunks, chunk)
+		manifest.Size += chunk.Size
+		if have[chunk.SHA256] {
+			continue
+		}
+		chunkPath := filepath.Join(dir, chunk.SHA256)
+		if err := os.WriteFile(chunkPath, data, 0o600); err != nil {
+			return 0, err
+		}
+		if err := p.Backend.UploadFileTo(apiKey, ChunkLocTag, chunkPath); err != nil {
+			logger.Error("Failed to upload chunk", err, "job", p.Job, "file", name, "chunk", chunk.SHA256)
+			p.recordFailedUpload(name, fileSize(localZipPath), start, err)
+			return 0, err
+		}
+		_ = os.Remove(chunkPath)
+		have[chunk.SHA256] = true
+		p.Ledger.Set(ChunkLocTag+"/"+chunk.SHA256, chunk.Size)
+		added += chunk.Size
+		addedChunks++
+	}
+	manifest.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	// The manifest goes last, so a stored manifest never refers to a
+	// missing chunk.
+	manifestName := name + ChunkManifestSuffix
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	manifestPath := filepath.Join(dir, manifestName)
+	if err := os.WriteFile(manifestPath, b, 0o600); err != nil {
+		return 0, err
+	}
+	if err := p.Backend.UploadFileTo(apiKey, p.LocTag, manifestPath); err != nil {
+		logger.Error("Failed to upload chunk manifest", err, "job", p.Job, "file", manifestName)
+		p.recordFailedUpload(name, fileSize(localZipPath), start, err)
+		return 0, err
+	}
+	added += int64(len(b))
+	p.Ledger.Set(manifestName, int64(len(b)))
+	p.Stats.FilesUploaded++
+	p.Stats.BytesUploaded += added
+	logger.Info("Uploaded chunked backup", "job", p.Job, "file", name, "size", manifest.Size, "chunks", len(manifest.Chunks), "new_chunks", addedChunks, "uploaded", added, "duration", time.Since(start).Round(time.Millisecond))
+
+	meta := p.newMeta(models.TxnUpload, name, manifestName, added, fmt.Sprintf("Uploaded as %d chunks, %d new; %d of %d bytes stored", len(manifest.Chunks), addedChunks, added, manifest.Size))
+	meta.Checksum = manifest.SHA256
+	meta.DurationMs = time.Since(start).Milliseconds()
+	if err := p.Backend.InsertFileMetadata(meta); err != nil {
+		logger.Error("Failed to insert upload metadata", err, "job", p.Job, "file", name)
+	}
+	return added, nil
+}
+
+// readManifest downloads and decodes the chunk manifest stored at key.
+func (p *Pipeline) readManifest(key string) (*ChunkManifest, error) {
+	var buf bytes.Buffer
+	if err := p.Backend.DownloadFile(p.Company.CompanyApiKey, key, &buf); err != nil {
+		return nil, err
+	}
+	var m ChunkManifest
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		return nil, fmt.Errorf("invalid chunk manifest %s: %w", key, err)
+	}
+	return &m, nil
+}
+
+// collectChunks deletes the stored chunks that no chunked backup, stored or
+// in the trash, refers to any more. It runs for every pipeline, since a
+// delete by any job can drop a chunked backup. Failures are logged; the
+// chunks are collected on the next delete.
+func (p *Pipeline) collectChunks() {
+	apiKey := p.Company.CompanyApiKey
+	chunks, err := p.Backend.ListFiles(apiKey, ChunkLocTag)
+	if err != nil {
+		logger.Error("Cannot list stored chunks", err, "job", p.Job, "folder", ChunkLocTag)
+		return
+	}
+	if len(chunks) == 0 {
+		return
+	}
+	var manifests []models.RemoteFile
+	stored, err := p.Backend.ListFiles(apiKey, p.LocTag)
+	if err == nil {
+		manifests = append(manifests, stored...)
+		var trashed []models.RemoteFile
+		trashed, err = p.Backend.ListTrash(apiKey, p.LocTag)
+		manifests = append(manifests, trashed...)
+	}
+	if err != nil {
+		logger.Error("Cannot list chunked backups, no chunks collected", err, "job", p.Job)
+		return
+	}
+	referenced := map[string]bool{}
+	for _, f := range manifests {
+		if !IsChunked(f.FileName) {
+			continue
+		}
+		m, err := p.readManifest(f.Key)
+		if err != nil {
+			logger.Error("Cannot read chunk manifest, no chunks collected", err, "job", p.Job, "file", f.FileName)
+			return
+		}
+		for _, c := range m.Chunks {
+			referenced[c.SHA256] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-chunkGrace)
+	var keys, names []string
+	var freed int64
+	for _, c := range chunks {
+		if referenced[c.FileName] || storedAt(c).After(cutoff) {
+			continue
+		}
+		keys = append(keys, c.Key)
+		names = append(names, ChunkLocTag+"/"+c.FileName)
+		freed += c.Size
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := p.Backend.DeleteFileKeys(apiKey, ChunkLocTag, keys); err != nil {
+		logger.Error("Cannot delete unreferenced chunks", err, "job", p.Job, "chunks", len(keys))
+		return
+	}
+	p.Ledger.Remove(names...)
+	p.Stats.BytesDeleted += freed
+	logger.Info("Deleted unreferenced chunks", "job", p.Job, "chunks", len(keys), "size", freed)
+}
+
+// storedSize is the usage of the backups in files plus the stored chunks.
+// Chunks are counted even when this pipeline is not chunked, since every
+// job shares the company's folders.
+func (p *Pipeline) storedSize(files []models.RemoteFile) int64 {
+	total := sizeOf(files)
+	chunks, err := p.Backend.ListFiles(p.Company.CompanyApiKey, ChunkLocTag)
+	if err != nil {
+		logger.Error("Cannot list stored chunks", err, "job", p.Job, "folder", ChunkLocTag)
+		return total
+	}
+	return total + sizeOf(chunks)
+}
+
+// Fetch downloads the named remote backup into dir, reassembling a chunked
+// backup from its chunks, and checks the result. It returns the path of
+// the downloaded archive.
+func (p *Pipeline) Fetch(name, dir string) (string, error) {
+	apiKey := p.Company.CompanyApiKey
+	files, err := p.Backend.ListFiles(apiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job)
+		return "", err
+	}
+	var matched []models.RemoteFile
+	for _, f := range files {
+		if f.FileName == name+ChunkManifestSuffix {
+			matched = []models.RemoteFile{f}
+			break
+		}
+	}
+	if matched == nil {
+		if matched, err = p.matchNames(backups(files), []string{name}); err != nil {
+			return "", err
+		}
+	}
+	f := matched[0]
+	start := time.Now()
+	target := filepath.Join(dir, strings.TrimSuffix(f.FileName, ChunkManifestSuffix))
+	if _, err := os.Stat(target); err == nil {
+		err := fmt.Errorf("%s already exists", target)
+		logger.Error("Cannot download backup", err, "job", p.Job, "file", f.FileName)
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".fetch-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = p.download(f, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = VerifyZip(tmp.Name())
+	}
+	if err != nil {
+		logger.Error("Failed to download backup", err, "job", p.Job, "file", f.FileName)
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return "", err
+	}
+	logger.Info("Downloaded backup", "job", p.Job, "file", filepath.Base(target), "path", target, "size", fileSize(target), "duration", time.Since(start).Round(time.Millisecond))
+	return target, nil
+}
+
+// download writes the remote backup f to w, reassembling it when it is
+// chunked.
+func (p *Pipeline) download(f models.RemoteFile, w io.Writer) error {
+	if IsChunked(f.FileName) {
+		return p.reassemble(f, w)
+	}
+	return p.Backend.DownloadFile(p.Company.CompanyApiKey, f.Key, w)
+}
+
+// reassemble writes the chunked backup whose manifest is f to w, checking
+// every chunk and the whole file against the manifest.
+func (p *Pipeline) reassemble(f models.RemoteFile, w io.Writer) error {
+	m, err := p.readManifest(f.Key)
+	if err != nil {
+		return err
+	}
+	chunks, err := p.Backend.ListFiles(p.Company.CompanyApiKey, ChunkLocTag)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]string, len(chunks))
+	for _, c := range chunks {
+		keys[c.FileName] = c.Key
+	}
+
+	whole := sha256.New()
+	out := io.MultiWriter(w, whole)
+	var buf bytes.Buffer
+	for i, c := range m.Chunks {
+		key, ok := keys[c.SHA256]
+		if !ok {
+			return fmt.Errorf("chunk %d (%s) of %s is missing", i, c.SHA256, m.FileName)
+		}
+		buf.Reset()
+		if err := p.Backend.DownloadFile(p.Company.CompanyApiKey, key, &buf); err != nil {
+			return err
+		}
+		if sum := sha256.Sum256(buf.Bytes()); hex.EncodeToString(sum[:]) != c.SHA256 || int64(buf.Len()) != c.Size {
+			return fmt.Errorf("chunk %d (%s) of %s is corrupt", i, c.SHA256, m.FileName)
+		}
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if sum := hex.EncodeToString(whole.Sum(nil)); sum != m.SHA256 {
+		return fmt.Errorf("reassembled %s has sha256 %s, expected %s", m.FileName, sum, m.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chunkCount is the number of chunks stored for the company.
+func (f *fixture) chunkCount() int {
+	f.t.Helper()
+	chunks, err := f.client.ListFiles(testAPIKey, ChunkLocTag)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return len(chunks)
+}
+
+func TestChunkedUploadRoundTrip(t *testing.T) {
+	f := newFixture(t)
+	f.p.Chunked = true
+	data := randomBytes(4, 6<<20)
+	original := f.backup("2026-03-01", data)
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if f.chunkCount() < 2 {
+		t.Fatalf("stored %d chunks, want the backup split", f.chunkCount())
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+
+	path, err := f.p.Fetch(backupName("2026-03-01"), t.TempDir())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	want, err := os.ReadFile(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("fetched %d bytes differ from the %d bytes uploaded", len(got), len(want))
+	}
+	if filepath.Base(path) != backupName("2026-03-01") {
+		t.Errorf("fetched to %s, want the backup's own name", path)
+	}
+}
+
+// The next day's backup with a small edit stores only the chunks around
+// the edit.
+func TestChunkedUploadDeduplicates(t *testing.T) {
+	f := newFixture(t)
+	f.p.Chunked = true
+	data := randomBytes(5, 8<<20)
+	f.backup("2026-03-01", data)
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("first Upload: %v", err)
+	}
+	before := f.chunkCount()
+
+	edited := bytes.Clone(data)
+	copy(edited[4<<20:], "an edited voucher")
+	f.backup("2026-03-02", edited)
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("second Upload: %v", err)
+	}
+	// The zip header and trailer around the data change too, as the CRC
+	// of the entry is different.
+	if added := f.chunkCount() - before; added == 0 || added > 3 {
+		t.Errorf("second upload stored %d new chunks of %d, want only those around the edit", added, f.chunkCount())
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+}
+
+// A job that is not chunked still counts the chunks another job stored.
+func TestReconcileCountsChunks(t *testing.T) {
+	f := newFixture(t)
+	f.p.Chunked = true
+	f.backup("2026-03-01", randomBytes(6, 2<<20))
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	f.p.Chunked = false
+	r, err := f.p.Reconcile(false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !r.InSync() || r.Remote != f.folderSize() {
+		t.Errorf("Reconcile = %+v, want in sync with the %d bytes stored", r, f.folderSize())
+	}
+}
@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"bufio"
+	"io"
+)
+
+// Chunk sizes of the content-defined chunker. Cut points depend only on
+// the bytes just before them, so an edit inside a backup changes the
+// chunks around it and leaves the rest identical to the previous backup's.
+const (
+	minChunkSize = 256 << 10
+	maxChunkSize = 4 << 20
+	// chunkMask selects 20 bits of the rolling hash, for an average chunk
+	// of about 1 MiB past minChunkSize.
+	chunkMask = (1<<20 - 1) << 44
+)
+
+// gear maps each byte value to a pseudo-random 64-bit number for the gear
+// rolling hash. It must never change: different values would cut every
+// backup differently and stop it deduplicating against stored chunks.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64 from a fixed seed.
+	x := uint64(0x5348424b55505331)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks.
+type chunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{r: bufio.NewReaderSize(r, 1<<20), buf: make([]byte, 0, maxChunkSize)}
+}
+
+// next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the following call.
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+	for len(c.buf) < maxChunkSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+		hash = hash<<1 + gear[b]
+		if len(c.buf) >= minChunkSize && hash&chunkMask == 0 {
+			break
+		}
+	}
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+	return c.buf, nil
+}
@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomBytes returns n reproducible pseudo-random bytes.
+func randomBytes(seed uint64, n int) []byte {
+	b := make([]byte, n)
+	r := rand.NewChaCha8([32]byte{byte(seed), byte(seed >> 8)})
+	_, _ = r.Read(b)
+	return b
+}
+
+func split(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	c := newChunker(bytes.NewReader(data))
+	var chunks [][]byte
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte{1}},
+		{"below the minimum", randomBytes(1, minChunkSize-1)},
+		{"random", randomBytes(2, 12<<20)},
+		// Zeros never match the cut mask, so every chunk is cut at the
+		// maximum size.
+		{"zeros", make([]byte, 3*maxChunkSize+5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := split(t, tt.data)
+			if got := bytes.Join(chunks, nil); !bytes.Equal(got, tt.data) {
+				t.Fatalf("chunks rejoin to %d bytes, want the %d input bytes", len(got), len(tt.data))
+			}
+			for i, chunk := range chunks {
+				if len(chunk) > maxChunkSize {
+					t.Errorf("chunk %d is %d bytes, above the maximum", i, len(chunk))
+				}
+				if i < len(chunks)-1 && len(chunk) < minChunkSize {
+					t.Errorf("chunk %d is %d bytes, below the minimum", i, len(chunk))
+				}
+			}
+		})
+	}
+}
+
+func TestChunkerZerosCutAtMaximum(t *testing.T) {
+	chunks := split(t, make([]byte, 3*maxChunkSize+5))
+	if len(chunks) != 4 || len(chunks[3]) != 5 {
+		t.Fatalf("got %d chunks, want 3 of the maximum size and one of 5 bytes", len(chunks))
+	}
+}
+
+// An edit inside a backup must only change the chunks around it, so the
+// rest deduplicates against the previous backup.
+func TestChunkerEditIsLocal(t *testing.T) {
+	original := randomBytes(3, 16<<20)
+	edited := bytes.Clone(original)
+	edited = append(edited[:8<<20], append([]byte("inserted by an edit"), edited[8<<20:]...)...)
+
+	stored := map[[32]byte]bool{}
+	for _, chunk := range split(t, original) {
+		stored[sha256.Sum256(chunk)] = true
+	}
+	chunks := split(t, edited)
+	changed := 0
+	for _, chunk := range chunks {
+		if !stored[sha256.Sum256(chunk)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Errorf("%d of %d chunks changed after a small insert, want 1 or 2", changed, len(chunks))
+	}
+}
@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+)
+
+// Delete removes the company's backups from S3. With applyCondition set and
+// a retention policy configured it prunes by that policy; without a policy
+// it deletes every backup but the newest once the folder has reached the
+// company's total quota. With applyCondition unset it always empties the
+// folder, after asking Confirm. Protected backups and the safeguards are honoured either way.
+func (p *Pipeline) Delete(applyCondition bool) error {
+	if applyCondition && !p.Retention.IsZero() {
+		return p.Prune()
+	}
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job, "folder", p.LocTag)
+		return err
+	}
+	contentSize := p.storedSize(files)
+	if applyCondition && (p.Company.TotalUsageQuota == nil || contentSize < *p.Company.TotalUsageQuota) {
+		logger.Info("Under valid quota usage, nothing deleted", "job", p.Job, "size", contentSize)
+		return nil
+	}
+	stored := backups(files)
+	if applyCondition {
+		// Never leave the company without a backup.
+		_, stored = Retention{KeepLast: 1}.Plan(stored, time.Now())
+	}
+	if len(stored) == 0 {
+		logger.Info("No remote backups to delete, nothing deleted", "job", p.Job, "folder", p.LocTag)
+		return nil
+	}
+
+	remaining, err := p.removeBackups(files, stored, "Deleted files in S3", !applyCondition)
+	if err != nil {
+		return err
+	}
+	usage := p.storedSize(remaining)
+	p.setUsedQuota(usage)
+	logger.Info("Deleted folder contents with Tally backups", "job", p.Job, "folder", p.LocTag, "size", contentSize-usage, "kept", len(backups(remaining)))
+	return nil
+}
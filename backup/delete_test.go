@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+func TestDeleteUnderQuotaDeletesNothing(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	if err := f.p.Delete(true); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-01", "2026-03-02"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+}
+
+// At quota without a retention policy, every backup but the newest goes.
+func TestDeleteAtQuotaKeepsNewest(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	f.upload("2026-03-03")
+	f.setQuota(1)
+
+	if err := f.p.Delete(true); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-03"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want only the newest backup %v", got, want)
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+	if deletes := f.transactions(models.TxnDelete); len(deletes) != 2 {
+		t.Errorf("got %d delete transactions, want 2", len(deletes))
+	}
+
+	// With only the newest left, a second delete has nothing to do.
+	if err := f.p.Delete(true); err != nil {
+		t.Fatalf("second Delete: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-03"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v after a second delete, want %v", got, want)
+	}
+}
+
+func TestDeleteAtQuotaPrunesByRetention(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	f.upload("2026-03-03")
+	f.p.Retention = Retention{KeepLast: 2}
+	if err := f.p.Delete(true); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-02", "2026-03-03"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteForcedAsksConfirm(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+
+	var asked []models.RemoteFile
+	f.p.Confirm = func(files []models.RemoteFile) bool {
+		asked = files
+		return false
+	}
+	if err := f.p.Delete(false); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("Delete = %v, want ErrNotConfirmed", err)
+	}
+	if len(asked) != 2 {
+		t.Errorf("Confirm was asked about %d backups, want 2", len(asked))
+	}
+	if got := f.remote(); len(got) != 4 {
+		t.Errorf("remote = %v, want nothing deleted", got)
+	}
+
+	f.p.Confirm = func([]models.RemoteFile) bool { return true }
+	if err := f.p.Delete(false); err != nil {
+		t.Fatalf("confirmed Delete: %v", err)
+	}
+	if got := f.remote(); len(got) != 0 {
+		t.Errorf("remote = %v, want the folder emptied", got)
+	}
+}
+
+func TestDeleteSparesProtected(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	if err := f.p.Protect([]string{backupName("2026-03-01")}, true); err != nil {
+		t.Fatal(err)
+	}
+	f.p.Confirm = func([]models.RemoteFile) bool { return true }
+	if err := f.p.Delete(false); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want only the protected backup and its manifest %v", got, want)
+	}
+}
+
+func TestDeleteRefusedWithoutRecentBackup(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2020-01-01")
+	f.upload("2020-01-02")
+	f.p.Safeguards.KeepRecent = 7 * 24 * time.Hour
+	f.setQuota(1)
+
+	err := f.p.DeleteSelected(Selection{Before: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if !errors.Is(err, ErrNoRecentBackup) {
+		t.Fatalf("DeleteSelected = %v, want ErrNoRecentBackup", err)
+	}
+	if got := f.remote(); len(got) != 4 {
+		t.Errorf("remote = %v, want nothing deleted", got)
+	}
+}
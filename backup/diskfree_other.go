@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package backup
+
+import "errors"
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
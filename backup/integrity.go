@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// ErrNoIntactBackup is returned when every local backup failed the zip
+// integrity check, so there was nothing to upload.
+var ErrNoIntactBackup = errors.New("no intact backup to upload")
+
+// VerifyZip checks that path is a complete zip archive: the central
+// directory must be readable and every entry must decompress with a
+// matching CRC-32.
+func VerifyZip(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if len(r.File) == 0 {
+		return fmt.Errorf("archive has no entries")
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		// The zip reader compares the CRC-32 once the entry is read to EOF.
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// rejectCorrupt records a corrupt local backup with the backend, so it
+// shows up next to the upload transactions.
+func (p *Pipeline) rejectCorrupt(path string, size int64, cause error) {
+	name := filepath.Base(path)
+	p.Stats.FilesSkipped++
+	logger.Error("Skipping corrupt backup", cause, "job", p.Job, "file", name, "size", size)
+	meta := p.newMeta(models.TxnSkipped, name, name, size, "Corrupt archive not uploaded: "+cause.Error())
+	meta.Error = cause.Error()
+	if err := p.Backend.InsertFileMetadata(meta); err != nil {
+		logger.Error("Failed to insert rejection metadata", err, "job", p.Job, "file", name)
+	}
+}
+
+// fileSize returns the size of path, or 0 when it cannot be read.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/runid"
+	"shreshtasmg.in/sh_backups/utils"
+)
+
+// ManifestSuffix is appended to a backup's archive name to name the
+// manifest stored next to it.
+const ManifestSuffix = ".manifest.json"
+
+// ErrNoManifest is returned for a backup uploaded without a manifest.
+var ErrNoManifest = errors.New("backup has no manifest")
+
+// Manifest describes an uploaded backup, so backups can be audited and
+// searched without downloading the archives.
+type Manifest struct {
+	Version  int    `json:"version"`
+	FileName string `json:"file_name"`
+	// SourcePath is where the backup was found on the uploading host.
+	SourcePath string `json:"source_path"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	// BackupDate is the date in the backup's file name, when it has one.
+	BackupDate  string          `json:"backup_date,omitempty"`
+	Chunked     bool            `json:"chunked,omitempty"`
+	Entries     []ManifestEntry `json:"entries"`
+	Host        string          `json:"host"`
+	OS          string          `json:"os"`
+	ToolVersion string          `json:"tool_version"`
+	RunID       string          `json:"run_id,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+}
+
+// ManifestEntry is one file inside a backup archive.
+type ManifestEntry struct {
+	Name           string `json:"name"`
+	Size           uint64 `json:"size"`
+	CompressedSize uint64 `json:"compressed_size"`
+	Modified       string `json:"modified"`
+}
+
+// IsManifest reports whether a remote file name is a backup manifest.
+func IsManifest(name string) bool {
+	return strings.HasSuffix(name, ManifestSuffix)
+}
+
+// manifestName is the name of the manifest of the backup stored as name,
+// which may be a chunk manifest.
+func manifestName(name string) string {
+	return strings.TrimSuffix(name, ChunkManifestSuffix) + ManifestSuffix
+}
+
+// backups drops the manifests from a listing of the backup folder.
+func backups(files []models.RemoteFile) []models.RemoteFile {
+	var out []models.RemoteFile
+	for _, f := range files {
+		if !IsManifest(f.FileName) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// manifestsOf returns the manifests in all that belong to files.
+func manifestsOf(all, files []models.RemoteFile) []models.RemoteFile {
+	names := make(map[string]bool, len(files))
+	for _, f := range files {
+		names[manifestName(f.FileName)] = true
+	}
+	var out []models.RemoteFile
+	for _, f := range all {
+		if IsManifest(f.FileName) && names[f.FileName] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// buildManifest describes the archive at localZipPath, found at source.
+func (p *Pipeline) buildManifest(source, localZipPath string) (*Manifest, error) {
+	name := filepath.Base(localZipPath)
+	m := &Manifest{
+		Version:     1,
+		FileName:    name,
+		SourcePath:  source,
+		Chunked:     p.Chunked,
+		OS:          runtime.GOOS + "/" + runtime.GOARCH,
+		ToolVersion: p.Version,
+		RunID:       runid.Current().Run,
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+	m.Host, _ = os.Hostname()
+	if date, ok := utils.BackupDate(name); ok {
+		m.BackupDate = date.Format(time.DateOnly)
+	}
+
+	f, err := os.Open(localZipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if m.Size, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	m.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	r, err := zip.NewReader(f, m.Size)
+	if err != nil {
+		return nil, err
+	}
+	m.Entries = make([]ManifestEntry, len(r.File))
+	for i, e := range r.File {
+		m.Entries[i] = ManifestEntry{
+			Name:           e.Name,
+			Size:           e.UncompressedSize64,
+			CompressedSize: e.CompressedSize64,
+			Modified:       e.Modified.Format(time.RFC3339),
+		}
+	}
+	return m, nil
+}
+
+// uploadManifest uploads the manifest of the archive at localZipPath next
+// to it. It returns the manifest's size.
+func (p *Pipeline) uploadManifest(source, localZipPath string, rotate bool) (int64, error) {
+	m, err := p.buildManifest(source, localZipPath)
+	if err != nil {
+		logger.Error("Cannot build backup manifest", err, "job", p.Job, "file", filepath.Base(localZipPath))
+		return 0, err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	dir, err := p.tempDir("manifest-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	name := m.FileName + ManifestSuffix
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return 0, err
+	}
+	upload := p.Backend.UploadFileTo
+	if rotate {
+		upload = p.Backend.RotateFileTo
+	}
+	if err := upload(p.Company.CompanyApiKey, p.LocTag, path); err != nil {
+		logger.Error("Failed to upload backup manifest", err, "job", p.Job, "file", name)
+		return 0, err
+	}
+	size := int64(len(b))
+	p.Ledger.Set(name, size)
+	logger.Info("Uploaded backup manifest", "job", p.Job, "file", name, "sha256", m.SHA256, "entries", len(m.Entries))
+	return size, nil
+}
+
+// ReadManifest downloads the manifest of the named remote backup.
+func (p *Pipeline) ReadManifest(name string) (*Manifest, error) {
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job)
+		return nil, err
+	}
+	matched, err := p.matchNames(backups(files), []string{name})
+	if err != nil {
+		return nil, err
+	}
+	manifests := manifestsOf(files, matched)
+	if len(manifests) == 0 {
+		logger.Error("Backup has no manifest", ErrNoManifest, "job", p.Job, "file", matched[0].FileName)
+		return nil, ErrNoManifest
+	}
+	return p.downloadManifest(manifests[0])
+}
+
+// downloadManifest downloads and decodes the manifest f.
+func (p *Pipeline) downloadManifest(f models.RemoteFile) (*Manifest, error) {
+	var buf bytes.Buffer
+	if err := p.Backend.DownloadFile(p.Company.CompanyApiKey, f.Key, &buf); err != nil {
+		logger.Error("Cannot download backup manifest", err, "job", p.Job, "file", f.FileName)
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", f.FileName, err)
+	}
+	return &m, nil
+}
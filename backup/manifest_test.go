@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/runid"
+)
+
+func TestManifest(t *testing.T) {
+	f := newFixture(t)
+	f.p.Version = "1.2.3"
+	run := runid.StartRun()
+	t.Cleanup(runid.EndRun)
+	path := f.backup("2026-03-01", []byte("tally data"))
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := f.p.ReadManifest(backupName("2026-03-01"))
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if m.Version != 1 || m.FileName != backupName("2026-03-01") || m.SourcePath != path {
+		t.Errorf("manifest names %q from %q (version %d), want the backup at %q", m.FileName, m.SourcePath, m.Version, path)
+	}
+	if m.Size != int64(len(data)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("manifest size %d sha256 %s, want %d and %x", m.Size, m.SHA256, len(data), sum)
+	}
+	if m.BackupDate != "2026-03-01" || m.ToolVersion != "1.2.3" || m.RunID != run || m.Chunked {
+		t.Errorf("manifest = %+v, want the backup date, tool version and run ID", m)
+	}
+	if len(m.Entries) != 1 || m.Entries[0].Name != "Company/data.900" || m.Entries[0].Size != uint64(len("tally data")) {
+		t.Errorf("manifest entries = %+v, want the archived data file", m.Entries)
+	}
+}
+
+// The manifest is charged to the quota with the backup it describes.
+func TestManifestCountsTowardsQuota(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes of the backup and its manifest", used, stored)
+	}
+}
+
+func TestReadManifestMissing(t *testing.T) {
+	f := newFixture(t)
+	path := f.backup("2026-03-01", []byte("tally data"))
+	if err := f.client.UploadFile(testAPIKey, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.p.ReadManifest(backupName("2026-03-01")); !errors.Is(err, ErrNoManifest) {
+		t.Errorf("ReadManifest = %v, want ErrNoManifest", err)
+	}
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/storage"
+)
+
+// Mirror keeps a copy of every uploaded backup in a local directory,
+// typically a NAS share, which is far faster to restore from than S3.
+type Mirror struct {
+	Dir string
+	// Retention prunes the mirror independently of the remote backups.
+	Retention Retention
+}
+
+// mirror copies the uploaded backup into the mirror directory, verifying
+// its checksum, records the copy with the backend and prunes the mirror.
+func (p *Pipeline) mirror(localZipPath string) error {
+	if p.Mirror == nil {
+		return nil
+	}
+	start := time.Now()
+	name := filepath.Base(localZipPath)
+	fs := &storage.Filesystem{DestName: "mirror", Dir: p.Mirror.Dir}
+	sum, err := fs.Copy(localZipPath)
+	if err != nil {
+		logger.Error("Failed to mirror backup", err, "job", p.Job, "file", name, "dir", p.Mirror.Dir)
+		return fmt.Errorf("mirror: %w", err)
+	}
+	size := fileSize(localZipPath)
+	p.Stats.FilesMirrored++
+	p.Stats.BytesMirrored += size
+	logger.Info("Mirrored backup", "job", p.Job, "file", name, "dir", p.Mirror.Dir, "size", size, "sha256", sum)
+
+	meta := p.newMeta(models.TxnMirror, name, "", size, fmt.Sprintf("Mirrored to %s (sha256 %s)", p.Mirror.Dir, sum))
+	meta.Checksum = sum
+	meta.DurationMs = time.Since(start).Milliseconds()
+	if err := p.Backend.InsertFileMetadata(meta); err != nil {
+		logger.Error("Failed to insert mirror metadata", err, "job", p.Job, "file", name)
+	}
+	return p.pruneMirror()
+}
+
+// pruneMirror deletes the mirrored backups that fall outside the mirror's
+// retention policy.
+func (p *Pipeline) pruneMirror() error {
+	if p.Mirror.Retention.IsZero() {
+		return nil
+	}
+	entries, err := os.ReadDir(p.Mirror.Dir)
+	if err != nil {
+		return fmt.Errorf("mirror: %w", err)
+	}
+	var files []models.RemoteFile
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || !strings.HasSuffix(e.Name(), ".zip") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, models.RemoteFile{
+			Key:          filepath.Join(p.Mirror.Dir, e.Name()),
+			FileName:     e.Name(),
+			Size:         info.Size(),
+			LastModified: &models.CustomTime{Time: info.ModTime()},
+		})
+	}
+	_, remove := p.Mirror.Retention.Plan(files, time.Now())
+	var failed int
+	for _, f := range remove {
+		if err := os.Remove(f.Key); err != nil {
+			logger.Error("Failed to prune mirrored backup", err, "job", p.Job, "file", f.FileName)
+			failed++
+			continue
+		}
+		logger.Info("Pruned mirrored backup", "job", p.Job, "file", f.FileName, "size", f.Size)
+	}
+	if failed > 0 {
+		return fmt.Errorf("mirror: %d backups could not be pruned", failed)
+	}
+	return nil
+}
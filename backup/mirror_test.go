@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+func TestMirror(t *testing.T) {
+	f := newFixture(t)
+	f.p.Mirror = &Mirror{Dir: t.TempDir()}
+	f.upload("2026-03-01")
+
+	want, err := os.ReadFile(filepath.Join(f.local, backupName("2026-03-01")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(f.p.Mirror.Dir, backupName("2026-03-01")))
+	if err != nil {
+		t.Fatalf("mirrored copy: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("mirrored copy differs from the backup")
+	}
+
+	records := f.transactions(models.TxnMirror)
+	if len(records) != 1 {
+		t.Fatalf("got %d mirror transactions, want 1", len(records))
+	}
+	sum := sha256.Sum256(want)
+	m := records[0]
+	if m.FileName != backupName("2026-03-01") || m.FileKey != "" || m.Checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("mirror record = %+v, want the backup with no key and its checksum", m)
+	}
+	if m.FileSize == nil || *m.FileSize != int64(len(want)) {
+		t.Errorf("mirror record size = %v, want %d", m.FileSize, len(want))
+	}
+	// The mirror is not stored remotely, so it is not charged to the quota.
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored remotely", used, stored)
+	}
+}
+
+func TestMirrorRetention(t *testing.T) {
+	f := newFixture(t)
+	f.p.Mirror = &Mirror{Dir: t.TempDir(), Retention: Retention{KeepLast: 1}}
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+
+	entries, err := os.ReadDir(f.p.Mirror.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mirrored []string
+	for _, e := range entries {
+		mirrored = append(mirrored, e.Name())
+	}
+	if want := []string{backupName("2026-03-02")}; !reflect.DeepEqual(mirrored, want) {
+		t.Errorf("mirror = %v, want %v", mirrored, want)
+	}
+	// The mirror's retention leaves the remote backups alone.
+	if got, want := f.remote(), withManifests("2026-03-01", "2026-03-02"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+}
+
+func TestMirrorFailureIsReported(t *testing.T) {
+	f := newFixture(t)
+	blocked := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocked, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f.p.Mirror = &Mirror{Dir: blocked}
+	f.backup("2026-03-01", []byte("tally data"))
+	if err := f.p.Upload(f.local); err == nil {
+		t.Fatal("Upload succeeded with an unusable mirror")
+	}
+	// The upload itself still went through.
+	if got, want := f.remote(), withManifests("2026-03-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+	if got := f.transactions(models.TxnMirror); len(got) != 0 {
+		t.Errorf("got %d mirror transactions, want none", len(got))
+	}
+}
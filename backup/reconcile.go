@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"fmt"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// Reconciliation compares the usage recorded in each place with what the
+// remote folder actually holds.
+type Reconciliation struct {
+	// Remote is the size of the remote folder, the source of truth.
+	Remote int64
+	// Recorded is the company's used quota as the backend reports it.
+	Recorded int64
+	// Ledger is the total of the local ledger; HasLedger is false when the
+	// pipeline has none.
+	Ledger    int64
+	HasLedger bool
+	// Corrected is set when the used quota or the ledger was fixed.
+	Corrected bool
+}
+
+// InSync reports whether the used quota and the ledger match the remote
+// folder.
+func (r Reconciliation) InSync() bool {
+	return r.Recorded == r.Remote && (!r.HasLedger || r.Ledger == r.Remote)
+}
+
+// Reconcile measures the remote folder, plus the chunk folder, and compares it with the company's used quota and the local
+// ledger, logging every discrepancy. With fix set it sets the used quota
+// to the remote size and rebuilds the ledger from the remote listing.
+func (p *Pipeline) Reconcile(fix bool) (Reconciliation, error) {
+	var r Reconciliation
+	folder, err := p.Backend.GetFolderSize(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot measure the remote folder", err, "job", p.Job, "folder", p.LocTag)
+		return r, err
+	}
+	r.Remote = folder.TotalSize
+	// The chunk folder is measured even when this pipeline is not chunked:
+	// every job shares the company's folders, and another may be.
+	chunks, err := p.Backend.GetFolderSize(p.Company.CompanyApiKey, ChunkLocTag)
+	if err != nil {
+		logger.Error("Cannot measure the remote folder", err, "job", p.Job, "folder", ChunkLocTag)
+		return r, err
+	}
+	r.Remote += chunks.TotalSize
+	// Fetch the company again: the operation that just ran changed its
+	// used quota.
+	company, err := p.Backend.FindCompanyByAPIKey(p.Company.CompanyApiKey)
+	if err != nil {
+		logger.Error("Failed to fetch company", err, "job", p.Job)
+		return r, err
+	}
+	if company.UsedQuota != nil {
+		r.Recorded = *company.UsedQuota
+	}
+	if p.Ledger != nil {
+		r.Ledger, r.HasLedger = p.Ledger.Total(), true
+	}
+
+	if r.Recorded != r.Remote {
+		logger.Warn("Used quota does not match the remote folder", "job", p.Job, "used_quota", r.Recorded, "remote", r.Remote, "difference", r.Recorded-r.Remote)
+	}
+	if r.HasLedger && r.Ledger != r.Remote {
+		logger.Warn("Local ledger does not match the remote folder", "job", p.Job, "ledger", r.Ledger, "remote", r.Remote, "difference", r.Ledger-r.Remote)
+	}
+	if r.InSync() {
+		logger.Info("Quota usage is in sync with the remote folder", "job", p.Job, "usage", r.Remote)
+		return r, nil
+	}
+	if !fix {
+		return r, nil
+	}
+
+	if r.Recorded != r.Remote {
+		p.setUsedQuota(r.Remote)
+		p.Company.UsedQuota = &r.Remote
+		logger.Info("Corrected used quota", "job", p.Job, "from", r.Recorded, "to", r.Remote)
+		meta := p.newMeta(models.TxnQuotaReconcile, p.LocTag, p.LocTag, r.Remote, fmt.Sprintf("Used quota corrected from %d to %d", r.Recorded, r.Remote))
+		if err := p.Backend.InsertFileMetadata(meta); err != nil {
+			logger.Error("Failed to insert reconcile metadata", err, "job", p.Job)
+		}
+	}
+	if r.HasLedger && r.Ledger != r.Remote {
+		files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+		if err != nil {
+			logger.Error("Cannot list remote backups to rebuild the ledger", err, "job", p.Job)
+			return r, err
+		}
+		chunks, err := p.Backend.ListFiles(p.Company.CompanyApiKey, ChunkLocTag)
+		if err != nil {
+			logger.Error("Cannot list stored chunks to rebuild the ledger", err, "job", p.Job)
+			return r, err
+		}
+		for _, c := range chunks {
+			c.FileName = ChunkLocTag + "/" + c.FileName
+			files = append(files, c)
+		}
+		p.Ledger.Replace(files)
+		logger.Info("Rebuilt local ledger from the remote listing", "job", p.Job, "backups", len(files))
+	}
+	r.Corrected = true
+	return r, nil
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"path/filepath"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/ledger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// drift adds n bytes to the used quota that no stored file accounts for.
+func (f *fixture) drift(n int64) {
+	f.t.Helper()
+	if err := f.client.UpdateCompanyQuota(&models.UpdateUsageQuota{UsedQuota: n, FileTxnType: models.TxnUpload}); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func TestReconcileInSync(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	r, err := f.p.Reconcile(true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !r.InSync() || r.Corrected || r.Remote != f.folderSize() {
+		t.Errorf("reconciliation = %+v, want in sync at %d bytes", r, f.folderSize())
+	}
+	if got := f.transactions(models.TxnQuotaReconcile); len(got) != 0 {
+		t.Errorf("got %d reconcile transactions, want none", len(got))
+	}
+}
+
+func TestReconcileReportsDriftWithoutFix(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.drift(500)
+	r, err := f.p.Reconcile(false)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if r.InSync() || r.Corrected || r.Recorded != r.Remote+500 {
+		t.Errorf("reconciliation = %+v, want 500 bytes of drift left uncorrected", r)
+	}
+	if used := f.usedQuota(); used != f.folderSize()+500 {
+		t.Errorf("used quota = %d, want it unchanged at %d", used, f.folderSize()+500)
+	}
+	if got := f.transactions(models.TxnQuotaReconcile); len(got) != 0 {
+		t.Errorf("got %d reconcile transactions, want none", len(got))
+	}
+}
+
+func TestReconcileCorrectsDrift(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	f.drift(500)
+	r, err := f.p.Reconcile(true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	stored := f.folderSize()
+	if !r.Corrected || r.Recorded != stored+500 || r.Remote != stored {
+		t.Errorf("reconciliation = %+v, want %d corrected to %d", r, stored+500, stored)
+	}
+	if used := f.usedQuota(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+	records := f.transactions(models.TxnQuotaReconcile)
+	if len(records) != 1 {
+		t.Fatalf("got %d reconcile transactions, want 1", len(records))
+	}
+	if got := records[0].FileSize; got == nil || *got != stored {
+		t.Errorf("reconcile record size = %v, want %d", got, stored)
+	}
+}
+
+func TestReconcileRebuildsLedger(t *testing.T) {
+	f := newFixture(t)
+	l, err := ledger.Load(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.p.Ledger = l
+	f.upload("2026-03-01")
+	l.Set("Tallybackupason01012020.zip", 999)
+
+	r, err := f.p.Reconcile(true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !r.HasLedger || !r.Corrected || r.Ledger != f.folderSize()+999 {
+		t.Errorf("reconciliation = %+v, want the ledger 999 bytes over", r)
+	}
+	if got := l.Total(); got != f.folderSize() {
+		t.Errorf("ledger total = %d after reconcile, want %d", got, f.folderSize())
+	}
+	// Only the ledger drifted, so the used quota needed no record.
+	if got := f.transactions(models.TxnQuotaReconcile); len(got) != 0 {
+		t.Errorf("got %d reconcile transactions, want none", len(got))
+	}
+}
@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/utils"
+)
+
+// Retention decides which remote backups Prune keeps. The newest backup is
+// always kept. Without any Keep rule every backup is kept unless MaxAge
+// removes it.
+type Retention struct {
+	// KeepLast keeps the N most recent backups.
+	KeepLast int
+	// KeepDaily, KeepWeekly and KeepMonthly keep the newest backup of each
+	// of the N most recent days, ISO weeks and months that have one.
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	// MaxAge removes backups older than this even if a Keep rule matches;
+	// 0 disables.
+	MaxAge time.Duration
+}
+
+func (r Retention) IsZero() bool {
+	return r == Retention{}
+}
+
+// Plan splits files into those to keep and those to remove, both newest
+// first. A backup's date comes from its Tallybackupason<DDMMYYYY>.zip name,
+// falling back to the time it was stored.
+func (r Retention) Plan(files []models.RemoteFile, now time.Time) (keep, remove []models.RemoteFile) {
+	sorted := newestFirst(files)
+
+	hasRules := r.KeepLast > 0 || r.KeepDaily > 0 || r.KeepWeekly > 0 || r.KeepMonthly > 0
+	kept := make([]bool, len(sorted))
+	if !hasRules {
+		for i := range kept {
+			kept[i] = true
+		}
+	}
+	for i := 0; i < len(sorted) && i < r.KeepLast; i++ {
+		kept[i] = true
+	}
+	keepGenerations(sorted, kept, r.KeepDaily, func(t time.Time) string { return t.Format(time.DateOnly) })
+	keepGenerations(sorted, kept, r.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepGenerations(sorted, kept, r.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		for i, f := range sorted {
+			if backupDate(f).Before(cutoff) {
+				kept[i] = false
+			}
+		}
+	}
+	if len(sorted) > 0 {
+		// Never remove the newest backup.
+		kept[0] = true
+	}
+
+	for i, f := range sorted {
+		if kept[i] {
+			keep = append(keep, f)
+		} else {
+			remove = append(remove, f)
+		}
+	}
+	return keep, remove
+}
+
+// newestFirst returns a copy of files sorted by backup date, newest first.
+func newestFirst(files []models.RemoteFile) []models.RemoteFile {
+	sorted := append([]models.RemoteFile(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		di, dj := backupDate(sorted[i]), backupDate(sorted[j])
+		if !di.Equal(dj) {
+			return di.After(dj)
+		}
+		return storedAt(sorted[i]).After(storedAt(sorted[j]))
+	})
+	return sorted
+}
+
+// keepGenerations marks the newest backup of each of the n most recent
+// periods; files must be sorted newest first.
+func keepGenerations(files []models.RemoteFile, kept []bool, n int, period func(time.Time) string) {
+	seen := map[string]bool{}
+	for i, f := range files {
+		if len(seen) >= n {
+			return
+		}
+		p := period(backupDate(f))
+		if !seen[p] {
+			seen[p] = true
+			kept[i] = true
+		}
+	}
+}
+
+func backupDate(f models.RemoteFile) time.Time {
+	if date, ok := utils.BackupDate(strings.TrimSuffix(f.FileName, ChunkManifestSuffix)); ok {
+		return date
+	}
+	return storedAt(f)
+}
+
+func storedAt(f models.RemoteFile) time.Time {
+	if f.LastModified == nil {
+		return time.Time{}
+	}
+	return f.LastModified.Time
+}
+
+// Prune deletes the remote backups that fall outside the retention policy,
+// records a delete transaction for each and sets the used quota to what
+// remains.
+func (p *Pipeline) Prune() error {
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job, "folder", p.LocTag)
+		return err
+	}
+	keep, remove := p.Retention.Plan(backups(files), time.Now())
+	if len(remove) == 0 {
+		logger.Info("All backups are within the retention policy, nothing deleted", "job", p.Job, "backups", len(keep))
+		return nil
+	}
+
+	remaining, err := p.removeBackups(files, remove, "Deleted by retention policy", false)
+	if err != nil {
+		return err
+	}
+	p.setUsedQuota(p.storedSize(remaining))
+	logger.Info("Pruned remote backups", "job", p.Job, "deleted", len(backups(files))-len(backups(remaining)), "kept", len(backups(remaining)))
+	return nil
+}
+
+// deleteRemote deletes the given backups and their manifests by key and
+// records a delete transaction for each backup, described by reason.
+func (p *Pipeline) deleteRemote(files, manifests []models.RemoteFile, reason string) error {
+	var keys []string
+	for _, f := range append(files, manifests...) {
+		keys = append(keys, f.Key)
+	}
+	deleteKeys := p.Backend.DeleteFileKeys
+	if p.Safeguards.Trash {
+		deleteKeys = p.Backend.TrashFiles
+		reason += " (moved to trash)"
+	}
+	if err := deleteKeys(p.Company.CompanyApiKey, p.LocTag, keys); err != nil {
+		logger.Error("Cannot delete remote backups", err, "job", p.Job, "files", len(keys))
+		return err
+	}
+	for _, f := range files {
+		meta := p.newMeta(models.TxnDelete, f.FileName, f.Key, f.Size, reason)
+		if err := p.Backend.InsertFileMetadata(meta); err != nil {
+			logger.Error("Failed to insert delete metadata", err, "job", p.Job, "file", f.FileName)
+		}
+		logger.Info("Deleted remote backup", "job", p.Job, "file", f.FileName, "size", f.Size, "reason", reason)
+		p.Ledger.Remove(f.FileName)
+		p.Stats.FilesDeleted++
+		p.Stats.BytesDeleted += f.Size
+	}
+	for _, m := range manifests {
+		p.Ledger.Remove(m.FileName)
+		p.Stats.BytesDeleted += m.Size
+	}
+	return nil
+}
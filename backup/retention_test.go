@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// dated returns a remote Tally backup dated day, given as YYYY-MM-DD.
+func dated(t *testing.T, day string) models.RemoteFile {
+	t.Helper()
+	date, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "Tallybackupason" + date.Format("02012006") + ".zip"
+	return models.RemoteFile{Key: "company/TallyBackups/" + name, FileName: name, Size: 100}
+}
+
+func datedAll(t *testing.T, days ...string) []models.RemoteFile {
+	t.Helper()
+	var files []models.RemoteFile
+	for _, day := range days {
+		files = append(files, dated(t, day))
+	}
+	return files
+}
+
+func TestRetentionPlan(t *testing.T) {
+	now := time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		retention Retention
+		days      []string
+		keep      []string
+	}{
+		{
+			name: "no rules keeps everything",
+			days: []string{"2026-03-01", "2026-03-30", "2025-12-31"},
+			keep: []string{"2026-03-30", "2026-03-01", "2025-12-31"},
+		},
+		{
+			name:      "keep last",
+			retention: Retention{KeepLast: 2},
+			days:      []string{"2026-03-28", "2026-03-30", "2026-03-29", "2026-03-27"},
+			keep:      []string{"2026-03-30", "2026-03-29"},
+		},
+		{
+			name:      "keep last larger than the listing",
+			retention: Retention{KeepLast: 10},
+			days:      []string{"2026-03-28", "2026-03-30"},
+			keep:      []string{"2026-03-30", "2026-03-28"},
+		},
+		{
+			name:      "keep daily skips empty days",
+			retention: Retention{KeepDaily: 3},
+			days:      []string{"2026-03-30", "2026-03-27", "2026-03-20", "2026-03-10"},
+			keep:      []string{"2026-03-30", "2026-03-27", "2026-03-20"},
+		},
+		{
+			name:      "keep weekly keeps the newest of each ISO week",
+			retention: Retention{KeepWeekly: 2},
+			days:      []string{"2026-03-30", "2026-03-29", "2026-03-25", "2026-03-18"},
+			keep:      []string{"2026-03-30", "2026-03-29"},
+		},
+		{
+			name:      "keep monthly keeps the newest of each month",
+			retention: Retention{KeepMonthly: 2},
+			days:      []string{"2026-03-30", "2026-03-01", "2026-02-15", "2026-02-01", "2026-01-10"},
+			keep:      []string{"2026-03-30", "2026-02-15"},
+		},
+		{
+			name:      "rules combine",
+			retention: Retention{KeepLast: 1, KeepMonthly: 3},
+			days:      []string{"2026-03-30", "2026-03-29", "2026-02-15", "2026-01-10", "2025-12-01"},
+			keep:      []string{"2026-03-30", "2026-02-15", "2026-01-10"},
+		},
+		{
+			name:      "max age alone",
+			retention: Retention{MaxAge: 10 * 24 * time.Hour},
+			days:      []string{"2026-03-30", "2026-03-25", "2026-03-01"},
+			keep:      []string{"2026-03-30", "2026-03-25"},
+		},
+		{
+			name:      "max age overrides keep rules",
+			retention: Retention{KeepLast: 3, MaxAge: 10 * 24 * time.Hour},
+			days:      []string{"2026-03-30", "2026-03-25", "2026-03-01"},
+			keep:      []string{"2026-03-30", "2026-03-25"},
+		},
+		{
+			name:      "newest survives max age",
+			retention: Retention{MaxAge: 30 * 24 * time.Hour},
+			days:      []string{"2024-01-01", "2025-01-01"},
+			keep:      []string{"2025-01-01"},
+		},
+		{
+			name:      "newest survives keep rules",
+			retention: Retention{KeepDaily: 1, MaxAge: 24 * time.Hour},
+			days:      []string{"2026-01-01", "2025-06-01"},
+			keep:      []string{"2026-01-01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := datedAll(t, tt.days...)
+			keep, remove := tt.retention.Plan(files, now)
+			if want := names(datedAll(t, tt.keep...)); !reflect.DeepEqual(names(keep), want) {
+				t.Errorf("keep = %v, want %v", names(keep), want)
+			}
+			if len(keep)+len(remove) != len(files) {
+				t.Errorf("kept %d and removed %d of %d backups", len(keep), len(remove), len(files))
+			}
+		})
+	}
+}
+
+func TestRetentionPlanEmpty(t *testing.T) {
+	keep, remove := Retention{KeepLast: 1}.Plan(nil, time.Now())
+	if keep != nil || remove != nil {
+		t.Errorf("Plan(nil) = %v, %v; want nothing", keep, remove)
+	}
+}
+
+func TestRetentionPlanFallsBackToStoredTime(t *testing.T) {
+	now := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	stored := func(name string, at time.Time) models.RemoteFile {
+		return models.RemoteFile{Key: name, FileName: name, LastModified: &models.CustomTime{Time: at}}
+	}
+	files := []models.RemoteFile{
+		stored("manual-old.zip", now.AddDate(0, -2, 0)),
+		stored("manual-new.zip", now.AddDate(0, 0, -1)),
+		dated(t, "2026-03-15"),
+	}
+	keep, _ := Retention{MaxAge: 30 * 24 * time.Hour}.Plan(files, now)
+	want := []string{"manual-new.zip", dated(t, "2026-03-15").FileName}
+	if !reflect.DeepEqual(names(keep), want) {
+		t.Errorf("keep = %v, want %v", names(keep), want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	f := newFixture(t)
+	for _, day := range []string{"2026-03-01", "2026-03-02", "2026-03-03", "2026-03-04"} {
+		f.upload(day)
+	}
+	f.p.Retention = Retention{KeepLast: 2}
+	if err := f.p.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-03", "2026-03-04"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+	if deletes := f.transactions(models.TxnDelete); len(deletes) != 2 {
+		t.Errorf("got %d delete transactions, want 2", len(deletes))
+	}
+}
+
+// A maximum age every backup is past must still leave the newest one.
+func TestPruneKeepsNewest(t *testing.T) {
+	f := newFixture(t)
+	for _, day := range []string{"2020-01-01", "2020-01-02", "2020-01-03"} {
+		f.upload(day)
+	}
+	f.p.Retention = Retention{MaxAge: 30 * 24 * time.Hour}
+	if err := f.p.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2020-01-03"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want only the newest backup %v", got, want)
+	}
+}
+
+func TestPruneToTrash(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	f.p.Safeguards = Safeguards{Trash: true, TrashRetention: 30 * 24 * time.Hour}
+	f.p.Retention = Retention{KeepLast: 1}
+	if err := f.p.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	trashed, err := f.client.ListTrash(testAPIKey, f.p.LocTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(trashed), withManifests("2026-03-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("trash = %v, want %v", got, want)
+	}
+
+	if err := f.p.Restore([]string{backupName("2026-03-01")}); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-01", "2026-03-02"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v after restore, want %v", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// Rotate uploads the latest backup in localFolder and checks that it is
+// stored with the expected size. Only then does it delete just enough of the
+// oldest remote backups to bring usage under the company quota, and sets the
+// used quota to the real resulting usage. The new backup is never deleted,
+// so a failed upload leaves the existing backups untouched.
+func (p *Pipeline) Rotate(localFolder string) error {
+	if p.Chunked {
+		return ErrChunkedRotate
+	}
+	source, localZipPath, cleanup, err := p.latestBackup(localFolder)
+	if err != nil || localZipPath == "" {
+		return err
+	}
+	defer cleanup()
+	name := filepath.Base(localZipPath)
+	size, err := p.upload(localZipPath, true)
+	if err != nil {
+		return err
+	}
+	_, manifestErr := p.uploadManifest(source, localZipPath, true)
+
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups to verify the upload", err, "job", p.Job, "file", name)
+		return err
+	}
+	if err := verifyStored(files, name, size); err != nil {
+		logger.Error("Uploaded backup failed verification, nothing deleted", err, "job", p.Job, "file", name)
+		return err
+	}
+
+	usage := sizeOf(files)
+	var remove []models.RemoteFile
+	if quota := p.Company.TotalUsageQuota; quota != nil && usage > *quota {
+		sorted := newestFirst(backups(files))
+		for i := len(sorted) - 1; i >= 0 && usage > *quota; i-- {
+			if sorted[i].FileName == name || sorted[i].Protected {
+				continue
+			}
+			remove = append(remove, sorted[i])
+			// The backup's manifest goes with it.
+			usage -= sorted[i].Size + sizeOf(manifestsOf(files, sorted[i:i+1]))
+		}
+		if usage > *quota {
+			logger.Warn("Usage stays above quota after removing every unprotected older backup", "job", p.Job, "usage", usage, "quota", *quota)
+		}
+	}
+	remaining, err := p.removeBackups(files, remove, "Rotated out to stay under quota", false)
+	// The new backup is stored either way; record the usage as it now stands.
+	usage = sizeOf(remaining)
+	p.setUsedQuota(usage)
+	if err != nil {
+		return err
+	}
+	logger.Info("Rotated backups", "job", p.Job, "file", name, "deleted", len(remove), "usage", usage)
+	return errors.Join(manifestErr, p.replicate(localZipPath))
+}
+
+// verifyStored checks that the listing holds name with the uploaded size.
+func verifyStored(files []models.RemoteFile, name string, size int64) error {
+	for _, f := range files {
+		if f.FileName == name {
+			if f.Size != size {
+				return fmt.Errorf("stored size of %s is %d bytes, expected %d", name, f.Size, size)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is missing from the remote listing", name)
+}
+
+// setUsedQuota sets the company's used quota to usage.
+func (p *Pipeline) setUsedQuota(usage int64) {
+	updateQuota := &models.UpdateUsageQuota{
+		UsedQuota:   usage,
+		FileTxnType: models.TxnDelete,
+	}
+	if err := p.Backend.UpdateCompanyQuota(updateQuota); err != nil {
+		logger.Error("Failed to update company quota", err, "job", p.Job, "usage", usage)
+	}
+}
+
+func sizeOf(files []models.RemoteFile) int64 {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	return total
+}
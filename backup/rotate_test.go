@@ -0,0 +1,113 @@
+package backup
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+func TestRotateDeletesOldestToFitQuota(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	f.upload("2026-03-03")
+	// Room for about two backups with their manifests.
+	f.setQuota(f.folderSize()*2/3 + 200)
+
+	f.backup("2026-03-04", []byte("tally data of 2026-03-04"))
+	if err := f.p.Rotate(f.local); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	remote := f.remote()
+	for _, gone := range []string{"2026-03-01", "2026-03-02"} {
+		for _, n := range remote {
+			if n == backupName(gone) {
+				t.Errorf("%s was not rotated out: remote = %v", gone, remote)
+			}
+		}
+	}
+	if !reflect.DeepEqual(remote, withManifests("2026-03-03", "2026-03-04")) {
+		t.Errorf("remote = %v, want the two newest backups", remote)
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+	if deletes := f.transactions(models.TxnDelete); len(deletes) != 2 {
+		t.Errorf("got %d delete transactions, want 2", len(deletes))
+	}
+}
+
+// The backend is already full, so the new backup only fits by going over
+// quota until the oldest backup is deleted.
+func TestRotateAtQuota(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	f.setQuota(f.folderSize())
+
+	f.backup("2026-03-03", []byte("tally data of 2026-03-03"))
+	if err := f.p.Rotate(f.local); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-02", "2026-03-03"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+	if used := f.usedQuota(); used > *f.p.Company.TotalUsageQuota {
+		t.Errorf("used quota = %d, over the %d quota", used, *f.p.Company.TotalUsageQuota)
+	}
+}
+
+// A backup larger than the whole quota is refused before anything is
+// deleted.
+func TestRotateRefusesBackupLargerThanQuota(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.setQuota(1)
+
+	f.backup("2026-03-02", []byte("tally data of 2026-03-02"))
+	if err := f.p.Rotate(f.local); err == nil {
+		t.Fatal("Rotate succeeded")
+	}
+	if got, want := f.remote(), withManifests("2026-03-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want it untouched %v", got, want)
+	}
+}
+
+// Even when the new backup and its manifest are over quota, the backup is
+// never rotated out.
+func TestRotateKeepsNewestOverQuota(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	// The backup and its manifest each fit, but not together.
+	f.setQuota(f.folderSize() - 1)
+
+	f.backup("2026-03-02", []byte("tally data of 2026-03-02"))
+	if err := f.p.Rotate(f.local); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-02"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want only the new backup %v", got, want)
+	}
+}
+
+func TestRotateUnderQuotaDeletesNothing(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.backup("2026-03-02", []byte("tally data of 2026-03-02"))
+	if err := f.p.Rotate(f.local); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-01", "2026-03-02"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+}
+
+func TestRotateRefusesChunked(t *testing.T) {
+	f := newFixture(t)
+	f.p.Chunked = true
+	if err := f.p.Rotate(f.local); !errors.Is(err, ErrChunkedRotate) {
+		t.Errorf("Rotate = %v, want ErrChunkedRotate", err)
+	}
+}
@@ -0,0 +1,233 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// Safeguards restrict what Delete, Prune and Rotate may remove.
+type Safeguards struct {
+	// KeepRecent refuses any delete that would leave no stored, non-empty
+	// backup newer than this; 0 disables.
+	KeepRecent time.Duration
+	// Trash moves deleted backups to the company's trash instead of
+	// deleting them; they can be restored until TrashRetention has passed.
+	Trash          bool
+	TrashRetention time.Duration
+}
+
+var (
+	// ErrNoRecentBackup is returned when a delete would leave no backup
+	// newer than Safeguards.KeepRecent.
+	ErrNoRecentBackup = errors.New("refusing to delete: no recent backup would remain")
+	// ErrNotConfirmed is returned when Confirm declines a delete.
+	ErrNotConfirmed = errors.New("delete not confirmed")
+)
+
+// removeBackups deletes remove, a subset of the backups in the listing
+// all, with their manifests, after applying the safeguards: protected
+// backups are skipped, the delete is refused if no recent backup would
+// remain, and with confirm set Confirm is asked first. It returns the
+// files that remain.
+func (p *Pipeline) removeBackups(all, remove []models.RemoteFile, reason string, confirm bool) ([]models.RemoteFile, error) {
+	var allowed []models.RemoteFile
+	for _, f := range remove {
+		if f.Protected {
+			logger.Info("Skipping protected backup", "job", p.Job, "file", f.FileName)
+			continue
+		}
+		allowed = append(allowed, f)
+	}
+	manifests := manifestsOf(all, allowed)
+	removing := make(map[string]bool, len(allowed)+len(manifests))
+	for _, f := range append(allowed, manifests...) {
+		removing[f.Key] = true
+	}
+	var remaining []models.RemoteFile
+	for _, f := range all {
+		if !removing[f.Key] {
+			remaining = append(remaining, f)
+		}
+	}
+	if len(allowed) == 0 {
+		return remaining, nil
+	}
+
+	if keep := p.Safeguards.KeepRecent; keep > 0 {
+		cutoff := time.Now().Add(-keep)
+		recent := false
+		for _, f := range backups(remaining) {
+			recent = recent || (f.Size > 0 && !backupDate(f).Before(cutoff))
+		}
+		if !recent {
+			err := fmt.Errorf("%w (none newer than %s)", ErrNoRecentBackup, cutoff.Format(time.DateOnly))
+			logger.Error("Delete refused by safeguard", err, "job", p.Job, "files", len(allowed))
+			return all, err
+		}
+	}
+	if confirm && p.Confirm != nil && !p.Confirm(allowed) {
+		logger.Warn("Delete not confirmed, nothing deleted", "job", p.Job, "files", len(allowed))
+		return all, ErrNotConfirmed
+	}
+
+	if err := p.deleteRemote(allowed, manifests, reason); err != nil {
+		return all, err
+	}
+	if p.Safeguards.Trash {
+		p.purgeExpiredTrash()
+	}
+	p.collectChunks()
+	return remaining, nil
+}
+
+// purgeExpiredTrash permanently deletes trashed backups older than
+// TrashRetention. Failures are logged; they are retried on the next run.
+func (p *Pipeline) purgeExpiredTrash() {
+	trashed, err := p.Backend.ListTrash(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list trash", err, "job", p.Job)
+		return
+	}
+	cutoff := time.Now().Add(-p.Safeguards.TrashRetention)
+	var keys []string
+	for _, f := range trashed {
+		if storedAt(f).Before(cutoff) {
+			keys = append(keys, f.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	if err := p.Backend.PurgeTrash(p.Company.CompanyApiKey, p.LocTag, keys); err != nil {
+		logger.Error("Cannot purge expired trash", err, "job", p.Job, "files", len(keys))
+		return
+	}
+	logger.Info("Purged expired backups from trash", "job", p.Job, "files", len(keys))
+}
+
+// Restore moves the named backups and their manifests back from the
+// trash, records a restore transaction for each backup and sets the used
+// quota to the new usage.
+func (p *Pipeline) Restore(names []string) error {
+	trashed, err := p.Backend.ListTrash(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list trash", err, "job", p.Job)
+		return err
+	}
+	files, err := p.matchNames(backups(trashed), names)
+	if err != nil {
+		return err
+	}
+	manifests := manifestsOf(trashed, files)
+	var keys []string
+	for _, f := range append(files, manifests...) {
+		keys = append(keys, f.Key)
+	}
+	if err := p.Backend.RestoreFiles(p.Company.CompanyApiKey, p.LocTag, keys); err != nil {
+		logger.Error("Cannot restore backups from trash", err, "job", p.Job, "files", len(keys))
+		return err
+	}
+	for _, f := range files {
+		meta := p.newMeta(models.TxnRestore, f.FileName, f.Key, f.Size, "Restored from trash")
+		if err := p.Backend.InsertFileMetadata(meta); err != nil {
+			logger.Error("Failed to insert restore metadata", err, "job", p.Job, "file", f.FileName)
+		}
+		logger.Info("Restored backup from trash", "job", p.Job, "file", f.FileName, "size", f.Size)
+		p.Ledger.Set(f.FileName, f.Size)
+	}
+	for _, m := range manifests {
+		p.Ledger.Set(m.FileName, m.Size)
+	}
+
+	stored, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job)
+		return err
+	}
+	p.setUsedQuota(p.storedSize(stored))
+	return nil
+}
+
+// PurgeTrash permanently deletes the named backups and their manifests
+// from the trash, or everything in the trash when names is empty.
+func (p *Pipeline) PurgeTrash(names []string) error {
+	trashed, err := p.Backend.ListTrash(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list trash", err, "job", p.Job)
+		return err
+	}
+	files := trashed
+	if len(names) > 0 {
+		if files, err = p.matchNames(backups(trashed), names); err != nil {
+			return err
+		}
+		files = append(files, manifestsOf(trashed, files)...)
+	}
+	if len(files) == 0 {
+		logger.Info("Trash is empty, nothing purged", "job", p.Job)
+		return nil
+	}
+	keys := make([]string, len(files))
+	for i, f := range files {
+		keys[i] = f.Key
+	}
+	if err := p.Backend.PurgeTrash(p.Company.CompanyApiKey, p.LocTag, keys); err != nil {
+		logger.Error("Cannot purge trash", err, "job", p.Job, "files", len(keys))
+		return err
+	}
+	logger.Info("Purged backups from trash", "job", p.Job, "files", len(keys))
+	p.collectChunks()
+	return nil
+}
+
+// Protect sets or clears the protected (legal hold) flag of the named
+// remote backups.
+func (p *Pipeline) Protect(names []string, protected bool) error {
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job)
+		return err
+	}
+	matched, err := p.matchNames(files, names)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(matched))
+	for i, f := range matched {
+		keys[i] = f.Key
+	}
+	if err := p.Backend.SetProtected(p.Company.CompanyApiKey, p.LocTag, keys, protected); err != nil {
+		logger.Error("Cannot change backup protection", err, "job", p.Job, "files", len(keys))
+		return err
+	}
+	for _, f := range matched {
+		logger.Info("Changed backup protection", "job", p.Job, "file", f.FileName, "protected", protected)
+	}
+	return nil
+}
+
+// matchNames picks the files whose name or key is in names, failing if any
+// name matches nothing.
+func (p *Pipeline) matchNames(files []models.RemoteFile, names []string) ([]models.RemoteFile, error) {
+	var matched []models.RemoteFile
+	for _, name := range names {
+		found := false
+		for _, f := range files {
+			if f.FileName == name || f.Key == name || f.FileName == name+ChunkManifestSuffix {
+				matched = append(matched, f)
+				found = true
+				break
+			}
+		}
+		if !found {
+			err := fmt.Errorf("no backup named %q", name)
+			logger.Error("Unknown backup", err, "job", p.Job)
+			return nil, err
+		}
+	}
+	return matched, nil
+}
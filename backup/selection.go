@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// Selection picks remote backups for DeleteSelected. Names and the date
+// bounds combine: a backup must match every rule that is set. A backup's
+// date is the one Retention uses.
+type Selection struct {
+	// Names lists backups by file name or key.
+	Names []string
+	// Before keeps only backups dated before this time.
+	Before time.Time
+	// From and To keep only backups dated within [From, To], both days
+	// inclusive.
+	From time.Time
+	To   time.Time
+}
+
+func (s Selection) IsZero() bool {
+	return len(s.Names) == 0 && s.Before.IsZero() && s.From.IsZero() && s.To.IsZero()
+}
+
+// String describes the selection for logs and delete metadata.
+func (s Selection) String() string {
+	var parts []string
+	if len(s.Names) > 0 {
+		parts = append(parts, fmt.Sprintf("%d named", len(s.Names)))
+	}
+	if !s.Before.IsZero() {
+		parts = append(parts, "before "+s.Before.Format(time.DateOnly))
+	}
+	if !s.From.IsZero() {
+		parts = append(parts, "from "+s.From.Format(time.DateOnly))
+	}
+	if !s.To.IsZero() {
+		parts = append(parts, "to "+s.To.Format(time.DateOnly))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Match reports whether the date bounds of s include f.
+func (s Selection) Match(f models.RemoteFile) bool {
+	date := backupDate(f)
+	if !s.Before.IsZero() && !date.Before(s.Before) {
+		return false
+	}
+	if !s.From.IsZero() && date.Before(s.From) {
+		return false
+	}
+	if !s.To.IsZero() && !date.Before(s.To.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// DeleteSelected deletes the remote backups picked by sel, after asking
+// Confirm, records a delete transaction for each and sets the used quota to
+// what remains. Every name in sel must match a stored backup.
+func (p *Pipeline) DeleteSelected(sel Selection) error {
+	if sel.IsZero() {
+		return errors.New("empty selection: name backups or give a date bound")
+	}
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job, "folder", p.LocTag)
+		return err
+	}
+	candidates := backups(files)
+	if len(sel.Names) > 0 {
+		if candidates, err = p.matchNames(candidates, sel.Names); err != nil {
+			return err
+		}
+	}
+	var remove []models.RemoteFile
+	for _, f := range candidates {
+		if sel.Match(f) {
+			remove = append(remove, f)
+		}
+	}
+	if len(remove) == 0 {
+		logger.Info("No remote backups match the selection, nothing deleted", "job", p.Job, "selection", sel.String())
+		return nil
+	}
+
+	remaining, err := p.removeBackups(files, remove, "Deleted by selection: "+sel.String(), true)
+	if err != nil {
+		return err
+	}
+	p.setUsedQuota(p.storedSize(remaining))
+	logger.Info("Deleted selected remote backups", "job", p.Job, "selection", sel.String(), "deleted", len(backups(files))-len(backups(remaining)), "kept", len(backups(remaining)))
+	return nil
+}
@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectionMatch(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		name string
+		sel  Selection
+		day  string
+		want bool
+	}{
+		{"no bounds", Selection{Names: []string{"x"}}, "2026-03-15", true},
+		{"before excludes the day itself", Selection{Before: day("2026-03-15")}, "2026-03-15", false},
+		{"before", Selection{Before: day("2026-03-15")}, "2026-03-14", true},
+		{"from includes the day itself", Selection{From: day("2026-03-15")}, "2026-03-15", true},
+		{"from", Selection{From: day("2026-03-15")}, "2026-03-14", false},
+		{"to includes the day itself", Selection{To: day("2026-03-15")}, "2026-03-15", true},
+		{"to", Selection{To: day("2026-03-15")}, "2026-03-16", false},
+		{"inside range", Selection{From: day("2026-03-01"), To: day("2026-03-31")}, "2026-03-15", true},
+		{"range start", Selection{From: day("2026-03-01"), To: day("2026-03-31")}, "2026-03-01", true},
+		{"range end", Selection{From: day("2026-03-01"), To: day("2026-03-31")}, "2026-03-31", true},
+		{"after range", Selection{From: day("2026-03-01"), To: day("2026-03-31")}, "2026-04-01", false},
+		{"before and range combine", Selection{Before: day("2026-03-10"), From: day("2026-03-01"), To: day("2026-03-31")}, "2026-03-15", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sel.Match(dated(t, tt.day)); got != tt.want {
+				t.Errorf("Match(%s) = %v, want %v", tt.day, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectionIsZero(t *testing.T) {
+	if !(Selection{}).IsZero() {
+		t.Error("empty selection is not zero")
+	}
+	if (Selection{Before: time.Now()}).IsZero() {
+		t.Error("selection with a bound is zero")
+	}
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+)
+
+const (
+	// stagingReserve is the free space left on the staging volume after a
+	// snapshot.
+	stagingReserve = 64 << 20
+	// stagingAttempts is how often a snapshot is retaken when the source
+	// changes while it is copied.
+	stagingAttempts = 3
+	// staleStaging is the age after which snapshots left behind by an
+	// interrupted run are removed.
+	staleStaging = 24 * time.Hour
+)
+
+// ErrSourceChanged is returned when a backup keeps changing while it is
+// copied into the staging directory.
+var ErrSourceChanged = errors.New("backup changed while it was being copied")
+
+// copyFile and stagingBackoff are variables so tests can change the source
+// mid-copy without waiting between attempts.
+var (
+	copyFile       = copyHashed
+	stagingBackoff = time.Second
+)
+
+// stage copies src into a new directory under p.StagingDir and checks that
+// the copy hashes the same as the source does afterwards, so a backup that
+// Tally rewrites mid-copy is never uploaded. It returns the snapshot's path
+// and a function that removes it. Without a StagingDir, src is used as is.
+func (p *Pipeline) stage(src string) (string, func(), error) {
+	if p.StagingDir == "" {
+		return src, func() {}, nil
+	}
+	if err := os.MkdirAll(p.StagingDir, 0o700); err != nil {
+		return "", nil, fmt.Errorf("cannot create staging directory: %w", err)
+	}
+	p.removeStaleSnapshots()
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", nil, err
+	}
+	if free, err := freeSpace(p.StagingDir); err != nil {
+		logger.Warn("Cannot check free space for staging", "job", p.Job, "dir", p.StagingDir, "error", err)
+	} else if need := uint64(info.Size()) + stagingReserve; free < need {
+		return "", nil, fmt.Errorf("not enough free space in %s for a snapshot: %d bytes free, %d needed", p.StagingDir, free, need)
+	}
+
+	dir, err := os.MkdirTemp(p.StagingDir, "upload-")
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot create staging directory: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Warn("Cannot remove staged snapshot", "job", p.Job, "dir", dir, "error", err)
+		}
+	}
+	dst := filepath.Join(dir, filepath.Base(src))
+	for attempt := 1; attempt <= stagingAttempts; attempt++ {
+		staged, err := copyFile(src, dst)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		source, err := hashFile(src)
+		if err != nil {
+			cleanup()
+			return "", nil, err
+		}
+		if bytes.Equal(staged, source) {
+			logger.Debug("Staged backup snapshot", "job", p.Job, "file", filepath.Base(src), "snapshot", dst, "sha256", fmt.Sprintf("%x", staged))
+			return dst, cleanup, nil
+		}
+		logger.Warn("Backup changed while it was being copied, retrying", "job", p.Job, "file", filepath.Base(src), "attempt", attempt)
+		time.Sleep(time.Duration(attempt) * stagingBackoff)
+	}
+	cleanup()
+	return "", nil, ErrSourceChanged
+}
+
+// tempDir creates a new directory in StagingDir, or in the system's
+// temporary directory when there is none.
+func (p *Pipeline) tempDir(prefix string) (string, error) {
+	if p.StagingDir != "" {
+		if err := os.MkdirAll(p.StagingDir, 0o700); err != nil {
+			return "", fmt.Errorf("cannot create staging directory: %w", err)
+		}
+	}
+	return os.MkdirTemp(p.StagingDir, prefix)
+}
+
+// removeStaleSnapshots deletes snapshots left behind by interrupted runs.
+func (p *Pipeline) removeStaleSnapshots() {
+	entries, err := os.ReadDir(p.StagingDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), "upload-") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > staleStaging {
+			_ = os.RemoveAll(filepath.Join(p.StagingDir, e.Name()))
+		}
+	}
+}
+
+// copyHashed copies src to dst, syncing it to disk, and returns the SHA-256
+// of what was written.
+func copyHashed(src, dst string) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot copy backup to staging: %w", err)
+	}
+	return h.Sum(nil), nil
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
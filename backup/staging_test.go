@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// rewriteWhileCopying makes the next copies append to the source after
+// copying it, as if Tally rewrote the backup mid-copy, for the given number
+// of copies.
+func rewriteWhileCopying(t *testing.T, copies int) {
+	t.Helper()
+	orig, backoff := copyFile, stagingBackoff
+	t.Cleanup(func() { copyFile, stagingBackoff = orig, backoff })
+	stagingBackoff = 0
+	copyFile = func(src, dst string) ([]byte, error) {
+		sum, err := orig(src, dst)
+		if copies > 0 {
+			copies--
+			f, ferr := os.OpenFile(src, os.O_APPEND|os.O_WRONLY, 0)
+			if ferr != nil {
+				t.Fatal(ferr)
+			}
+			f.Write([]byte("more"))
+			f.Close()
+		}
+		return sum, err
+	}
+}
+
+func TestStagingRefusesChangingSource(t *testing.T) {
+	f := newFixture(t)
+	f.p.StagingDir = t.TempDir()
+	f.backup("2026-03-01", []byte("tally data"))
+	rewriteWhileCopying(t, stagingAttempts)
+
+	if err := f.p.Upload(f.local); !errors.Is(err, ErrSourceChanged) {
+		t.Fatalf("Upload = %v, want ErrSourceChanged", err)
+	}
+	if got := f.remote(); len(got) != 0 {
+		t.Errorf("remote = %v, want nothing uploaded", got)
+	}
+	if entries, _ := os.ReadDir(f.p.StagingDir); len(entries) != 0 {
+		t.Errorf("staging directory holds %d entries, want the snapshot removed", len(entries))
+	}
+}
+
+// A source that settles before the attempts run out is uploaded as it
+// ends up.
+func TestStagingRetriesUntilSourceSettles(t *testing.T) {
+	f := newFixture(t)
+	f.p.StagingDir = t.TempDir()
+	path := f.backup("2026-03-01", []byte("tally data"))
+	rewriteWhileCopying(t, stagingAttempts-1)
+
+	snapshot, cleanup, err := f.p.stage(path)
+	if err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	defer cleanup()
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("snapshot differs from the settled source")
+	}
+}
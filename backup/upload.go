@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/storage"
+	"shreshtasmg.in/sh_backups/utils"
+)
+
+// Upload sends the latest Tally backup in localFolder to S3 and records the
+// transaction and quota usage with the backend.
+func (p *Pipeline) Upload(localFolder string) error {
+	source, localZipPath, cleanup, err := p.latestBackup(localFolder)
+	if err != nil || localZipPath == "" {
+		return err
+	}
+	defer cleanup()
+	name := filepath.Base(localZipPath)
+	if p.Chunked {
+		name += ChunkManifestSuffix
+	}
+	// Uploading a backup that is already stored replaces it and its
+	// manifest, so only the difference in size is added to the used quota.
+	var previous int64
+	if files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag); err == nil {
+		for _, f := range files {
+			if f.FileName == name || f.FileName == manifestName(name) {
+				previous += f.Size
+			}
+		}
+	} else {
+		logger.Warn("Cannot list remote backups, assuming the upload is new", "job", p.Job, "error", err)
+	}
+	var size int64
+	if p.Chunked {
+		size, err = p.uploadChunked(localZipPath)
+	} else {
+		size, err = p.upload(localZipPath, false)
+	}
+	if err != nil {
+		return err
+	}
+	manifestSize, manifestErr := p.uploadManifest(source, localZipPath, false)
+	size += manifestSize
+
+	// Update company quota
+	updateQuota := &models.UpdateUsageQuota{
+		UsedQuota:   size - previous,
+		FileTxnType: models.TxnUpload,
+	}
+	err = p.Backend.UpdateCompanyQuota(updateQuota)
+	if err != nil {
+		logger.Error("Failed to update company quota", err, "job", p.Job, "size", size)
+	}
+	return errors.Join(manifestErr, p.replicate(localZipPath))
+}
+
+// replicate mirrors the uploaded backup and stores it in every extra
+// destination. A failed copy does not stop the others; the failures are
+// returned together.
+func (p *Pipeline) replicate(localZipPath string) error {
+	var errs []error
+	if err := p.mirror(localZipPath); err != nil {
+		errs = append(errs, err)
+	}
+	for _, d := range p.Destinations {
+		start := time.Now()
+		if err := d.Put(localZipPath); err != nil {
+			logger.Error("Failed to copy backup to destination", err, "job", p.Job, "destination", d.Name(), "file", filepath.Base(localZipPath))
+			errs = append(errs, fmt.Errorf("%s: %w", d.Name(), err))
+			continue
+		}
+		logger.Info("Copied backup to destination", "job", p.Job, "destination", d.Name(), "file", filepath.Base(localZipPath), "duration", time.Since(start).Round(time.Millisecond))
+	}
+	return errors.Join(errs...)
+}
+
+// latestBackup returns the newest Tally backup in localFolder that passes
+// the zip integrity check, or "" when the folder has no backups. Corrupt
+// archives are skipped and recorded, falling back to the previous one;
+// when none is intact it returns ErrNoIntactBackup.
+// It returns the backup's path and the path to upload from, which is a
+// snapshot in the staging directory when one is set; the caller must call
+// the returned cleanup once the upload is done.
+func (p *Pipeline) latestBackup(localFolder string) (string, string, func(), error) {
+	candidates, err := utils.FindZipFilesNewestFirst(localFolder)
+	if err != nil || len(candidates) == 0 {
+		logger.Error("Failed to find latest Tally file", err, "job", p.Job, "folder", localFolder)
+		return "", "", nil, nil
+	}
+	for i, source := range candidates {
+		size := fileSize(source)
+		if size == 0 {
+			logger.Warn("Skipping empty backup", "job", p.Job, "file", filepath.Base(source))
+			continue
+		}
+		p.Stats.FilesConsidered++
+		// Check the snapshot rather than the source, since the snapshot is
+		// what gets uploaded.
+		snapshot, cleanup, err := p.stage(source)
+		if err != nil {
+			logger.Error("Cannot snapshot backup for upload", err, "job", p.Job, "file", filepath.Base(source))
+			return "", "", nil, err
+		}
+		if err := VerifyZip(snapshot); err != nil {
+			cleanup()
+			p.rejectCorrupt(source, size, err)
+			continue
+		}
+		if i > 0 {
+			logger.Warn("Falling back to an older backup", "job", p.Job, "file", filepath.Base(source))
+		}
+		return source, snapshot, cleanup, nil
+	}
+	logger.Error("No intact Tally backup to upload", ErrNoIntactBackup, "job", p.Job, "folder", localFolder, "checked", len(candidates))
+	return "", "", nil, ErrNoIntactBackup
+}
+
+// upload sends localZipPath to S3 and records the upload transaction. It
+// returns the uploaded size. A rotating upload may go over quota, since the
+// rotation deletes the backups it replaces afterwards.
+func (p *Pipeline) upload(localZipPath string, rotate bool) (int64, error) {
+	start := time.Now()
+	uploadKey := filepath.Base(localZipPath)
+	// Upload .zip file from local folder
+	var err error
+	if rotate {
+		err = p.Backend.RotateFileTo(p.Company.CompanyApiKey, p.LocTag, localZipPath)
+	} else {
+		primary := &storage.Backend{Backend: p.Backend, APIKey: p.Company.CompanyApiKey}
+		err = primary.Put(localZipPath)
+	}
+	if err != nil {
+		logger.Error("Failed to upload file to S3", err, "job", p.Job, "file", uploadKey)
+		p.recordFailedUpload(uploadKey, fileSize(localZipPath), start, err)
+		return 0, err
+	}
+
+	// Get file size
+	info, err := os.Stat(localZipPath)
+	if err != nil {
+		logger.Error("Failed to stat uploaded file", err, "job", p.Job, "file", uploadKey)
+		return 0, err
+	}
+	size := info.Size()
+	p.Stats.FilesUploaded++
+	p.Stats.BytesUploaded += size
+	logger.Info("Uploaded file to S3", "job", p.Job, "file", uploadKey, "size", size, "duration", time.Since(start).Round(time.Millisecond))
+
+	// Insert upload metadata
+	meta := p.newMeta(models.TxnUpload, filepath.Base(localZipPath), uploadKey, size, "Uploaded to S3")
+	if sum, err := hashFile(localZipPath); err == nil {
+		meta.Checksum = hex.EncodeToString(sum)
+	}
+	meta.DurationMs = time.Since(start).Milliseconds()
+	if err := p.Backend.InsertFileMetadata(meta); err != nil {
+		logger.Error("Failed to insert upload metadata", err, "job", p.Job, "file", uploadKey)
+	}
+	p.Ledger.Set(uploadKey, size)
+	return size, nil
+}
+
+// recordFailedUpload records a failed upload of the backup name, so the
+// backend sees the attempt and why it failed.
+func (p *Pipeline) recordFailedUpload(name string, size int64, start time.Time, cause error) {
+	meta := p.newMeta(models.TxnFailedUpload, name, name, size, "Upload failed: "+cause.Error())
+	meta.DurationMs = time.Since(start).Milliseconds()
+	meta.Error = cause.Error()
+	if err := p.Backend.InsertFileMetadata(meta); err != nil {
+		logger.Error("Failed to insert failed upload metadata", err, "job", p.Job, "file", name)
+	}
+}
@@ -0,0 +1,113 @@
+package backup
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/runid"
+)
+
+func TestUpload(t *testing.T) {
+	f := newFixture(t)
+	f.backup("2026-03-01", []byte("older"))
+	newest := f.backup("2026-03-02", []byte("newer"))
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	if got, want := f.remote(), withManifests("2026-03-02"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want %v", got, want)
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d, want the %d bytes stored", used, stored)
+	}
+	uploads := f.transactions(models.TxnUpload)
+	if len(uploads) != 1 {
+		t.Fatalf("got %d upload transactions, want 1", len(uploads))
+	}
+	sum, err := hashFile(newest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := uploads[0]; u.FileName != backupName("2026-03-02") || u.Checksum != hex.EncodeToString(sum) || u.Host == "" {
+		t.Errorf("upload transaction = %+v, want the newest backup with its checksum and host", u)
+	}
+	if f.p.Stats.FilesUploaded != 1 {
+		t.Errorf("FilesUploaded = %d, want 1", f.p.Stats.FilesUploaded)
+	}
+}
+
+// Uploading a backup that is already stored replaces it, so the used
+// quota must not count it twice.
+func TestUploadAgainChargesOnce(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("second Upload: %v", err)
+	}
+	if used, stored := f.usedQuota(), f.folderSize(); used != stored {
+		t.Errorf("used quota = %d after uploading twice, want the %d bytes stored", used, stored)
+	}
+}
+
+func TestUploadEmptyFolder(t *testing.T) {
+	f := newFixture(t)
+	if err := f.p.Upload(f.local); err != nil {
+		t.Errorf("Upload of an empty folder = %v, want nil", err)
+	}
+}
+
+// Metadata records name the run and operation that wrote them.
+func TestUploadRecordsRunIDs(t *testing.T) {
+	f := newFixture(t)
+	run := runid.StartRun()
+	t.Cleanup(runid.EndRun)
+	op := runid.StartOperation()
+	f.upload("2026-03-01")
+
+	uploads := f.transactions(models.TxnUpload)
+	if len(uploads) != 1 {
+		t.Fatalf("got %d upload transactions, want 1", len(uploads))
+	}
+	if u := uploads[0]; u.RunId != run || !strings.HasSuffix(u.FileTxnMeta, "(run_id="+run+" op_id="+op+")") {
+		t.Errorf("upload transaction has run %q and meta %q, want run %s and op %s", u.RunId, u.FileTxnMeta, run, op)
+	}
+}
+
+func TestUploadSkipsCorruptBackup(t *testing.T) {
+	f := newFixture(t)
+	f.backup("2026-03-01", []byte("intact"))
+	corrupt := f.backup("2026-03-02", []byte("truncated below"))
+	if err := os.Truncate(corrupt, 40); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if got, want := f.remote(), withManifests("2026-03-01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remote = %v, want the older intact backup %v", got, want)
+	}
+	if skipped := f.transactions(models.TxnSkipped); len(skipped) != 1 || skipped[0].FileName != backupName("2026-03-02") || skipped[0].Error == "" {
+		t.Errorf("skipped transactions = %+v, want the corrupt backup with its error", skipped)
+	}
+}
+
+func TestUploadFailsWithoutIntactBackup(t *testing.T) {
+	f := newFixture(t)
+	for _, day := range []string{"2026-03-01", "2026-03-02"} {
+		if err := os.Truncate(f.backup(day, []byte("some data")), 30); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.p.Upload(f.local); !errors.Is(err, ErrNoIntactBackup) {
+		t.Fatalf("Upload = %v, want ErrNoIntactBackup", err)
+	}
+	if got := f.remote(); len(got) != 0 {
+		t.Errorf("remote = %v, want nothing uploaded", got)
+	}
+}
@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// Verify test-restores the latest remote backup, or sample backups picked
+// at random when sample is positive. Each is downloaded, checked against
+// the checksum recorded in its manifest and extracted into a temporary
+// directory, and the outcome is recorded as a verify transaction. Every
+// picked backup is checked; the failures are returned together.
+func (p *Pipeline) Verify(sample int) error {
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		logger.Error("Cannot list remote backups", err, "job", p.Job, "folder", p.LocTag)
+		return err
+	}
+	picked := newestFirst(backups(files))
+	if len(picked) == 0 {
+		logger.Info("No remote backups to verify", "job", p.Job, "folder", p.LocTag)
+		return nil
+	}
+	if sample <= 0 {
+		picked = picked[:1]
+	} else {
+		rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+		picked = picked[:min(sample, len(picked))]
+	}
+
+	var errs []error
+	for _, f := range picked {
+		if err := p.verify(files, f); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.FileName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verify test-restores f, one of the backups in the listing all, and
+// records the result.
+func (p *Pipeline) verify(all []models.RemoteFile, f models.RemoteFile) error {
+	start := time.Now()
+	name := strings.TrimSuffix(f.FileName, ChunkManifestSuffix)
+	sum, entries, err := p.testRestore(all, f)
+	duration := time.Since(start).Round(time.Millisecond)
+
+	msg := fmt.Sprintf("Verified: sha256 %s, %d entries extracted in %s", sum, entries, duration)
+	if err != nil {
+		msg = "Verification failed: " + err.Error()
+		logger.Error("Backup failed verification", err, "job", p.Job, "file", name, "duration", duration)
+	} else {
+		p.Stats.FilesVerified++
+		logger.Info("Verified backup", "job", p.Job, "file", name, "sha256", sum, "entries", entries, "duration", duration)
+	}
+	meta := p.newMeta(models.TxnVerify, name, f.Key, f.Size, msg)
+	meta.Checksum = sum
+	meta.DurationMs = duration.Milliseconds()
+	if err != nil {
+		meta.Error = err.Error()
+	}
+	if err := p.Backend.InsertFileMetadata(meta); err != nil {
+		logger.Error("Failed to insert verify metadata", err, "job", p.Job, "file", name)
+	}
+	return err
+}
+
+// testRestore downloads f into a temporary directory, checks it against
+// its manifest and extracts it. It returns the archive's SHA-256 and the
+// number of entries extracted.
+func (p *Pipeline) testRestore(all []models.RemoteFile, f models.RemoteFile) (string, int, error) {
+	dir, err := p.tempDir("verify-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, strings.TrimSuffix(f.FileName, ChunkManifestSuffix))
+	out, err := os.Create(archive)
+	if err != nil {
+		return "", 0, err
+	}
+	err = p.download(f, out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("download failed: %w", err)
+	}
+	raw, err := hashFile(archive)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := hex.EncodeToString(raw)
+
+	// A chunked backup was already checked against its chunk manifest while
+	// it was reassembled.
+	if manifests := manifestsOf(all, []models.RemoteFile{f}); len(manifests) > 0 {
+		m, err := p.downloadManifest(manifests[0])
+		if err != nil {
+			return sum, 0, err
+		}
+		if m.SHA256 != sum {
+			return sum, 0, fmt.Errorf("checksum mismatch: recorded %s", m.SHA256)
+		}
+	} else if !IsChunked(f.FileName) {
+		if size := fileSize(archive); size != f.Size {
+			return sum, 0, fmt.Errorf("downloaded %d bytes, expected %d", size, f.Size)
+		}
+		logger.Warn("Backup has no manifest, checked its size only", "job", p.Job, "file", f.FileName)
+	}
+
+	entries, err := extractZip(archive, filepath.Join(dir, "extract"))
+	if err != nil {
+		return sum, entries, fmt.Errorf("extract failed: %w", err)
+	}
+	return sum, entries, nil
+}
+
+// extractZip extracts every entry of the archive at path into dir, which
+// also checks each entry's CRC. It returns the number of entries
+// extracted.
+func extractZip(path, dir string) (int, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n := 0
+	for _, e := range r.File {
+		if !filepath.IsLocal(e.Name) {
+			return n, fmt.Errorf("entry %q escapes the extract directory", e.Name)
+		}
+		target := filepath.Join(dir, e.Name)
+		if e.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := extractEntry(e, target); err != nil {
+			return n, fmt.Errorf("%s: %w", e.Name, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func extractEntry(e *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	src, err := e.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// truncatingBackend cuts every download short, as a dropped connection
+// would.
+type truncatingBackend struct {
+	api.Backend
+}
+
+func (b truncatingBackend) DownloadFile(apiKey, key string, w io.Writer) error {
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(b.Backend.DownloadFile(apiKey, key, pw)) }()
+	_, err := io.CopyN(w, pr, 16)
+	pr.Close()
+	return err
+}
+
+// replace overwrites the stored backup dated day with content, leaving its
+// manifest as it was.
+func (f *fixture) replace(day string, content []byte) {
+	f.t.Helper()
+	path := filepath.Join(f.t.TempDir(), backupName(day))
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.client.UploadFileTo(testAPIKey, f.p.LocTag, path); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// verifyRecord returns the single verify transaction.
+func (f *fixture) verifyRecord() models.FileMetadata {
+	f.t.Helper()
+	records := f.transactions(models.TxnVerify)
+	if len(records) != 1 {
+		f.t.Fatalf("got %d verify transactions, want 1", len(records))
+	}
+	return records[0]
+}
+
+func TestVerify(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.upload("2026-03-02")
+	if err := f.p.Verify(0); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	m := f.verifyRecord()
+	if m.FileName != backupName("2026-03-02") || m.Error != "" || m.Checksum == "" {
+		t.Errorf("verify record = %+v, want the newest backup verified", m)
+	}
+	if f.p.Stats.FilesVerified != 1 {
+		t.Errorf("FilesVerified = %d, want 1", f.p.Stats.FilesVerified)
+	}
+}
+
+func TestVerifyChecksumMismatch(t *testing.T) {
+	f := newFixture(t)
+	path := f.backup("2026-03-01", []byte("tally data"))
+	if err := f.p.Upload(f.local); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same size, one byte changed.
+	data[len(data)/2] ^= 0xff
+	f.replace("2026-03-01", data)
+
+	if err := f.p.Verify(0); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Verify = %v, want a checksum mismatch", err)
+	}
+	if m := f.verifyRecord(); !strings.Contains(m.Error, "checksum mismatch") {
+		t.Errorf("verify record error = %q, want the checksum mismatch", m.Error)
+	}
+}
+
+func TestVerifyTruncatedDownload(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.p.Backend = truncatingBackend{f.p.Backend}
+
+	if err := f.p.Verify(0); err == nil {
+		t.Fatal("Verify of a truncated download succeeded")
+	}
+	if m := f.verifyRecord(); m.Error == "" {
+		t.Errorf("verify record = %+v, want the failure recorded", m)
+	}
+}
+
+// Without a manifest, a corrupt archive is caught when it is extracted.
+func TestVerifyCorruptArchiveWithoutManifest(t *testing.T) {
+	f := newFixture(t)
+	f.replace("2026-03-01", []byte("not a zip archive"))
+
+	err := f.p.Verify(0)
+	if err == nil || !strings.Contains(err.Error(), "extract failed") {
+		t.Fatalf("Verify = %v, want the extract to fail", err)
+	}
+	if m := f.verifyRecord(); m.Error == "" {
+		t.Errorf("verify record = %+v, want the failure recorded", m)
+	}
+}
+
+func TestVerifyEmptyFolder(t *testing.T) {
+	f := newFixture(t)
+	if err := f.p.Verify(3); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got := f.transactions(models.TxnVerify); len(got) != 0 {
+		t.Errorf("got %d verify transactions, want none", len(got))
+	}
+}
+
+// Every sampled backup is checked, and one failure does not hide the
+// others.
+func TestVerifySampleReportsEachFailure(t *testing.T) {
+	f := newFixture(t)
+	f.upload("2026-03-01")
+	f.replace("2026-03-02", []byte("not a zip archive"))
+	f.replace("2026-03-03", []byte("not a zip archive either"))
+
+	err := f.p.Verify(10)
+	for _, day := range []string{"2026-03-02", "2026-03-03"} {
+		if err == nil || !strings.Contains(err.Error(), backupName(day)) {
+			t.Errorf("Verify = %v, want %s reported", err, backupName(day))
+		}
+	}
+	if err != nil && strings.Contains(err.Error(), backupName("2026-03-01")) {
+		t.Errorf("Verify = %v, reported the intact backup", err)
+	}
+	if got := f.transactions(models.TxnVerify); len(got) != 3 {
+		t.Errorf("got %d verify transactions, want 3", len(got))
+	}
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) || len(joined.Unwrap()) != 2 {
+		t.Errorf("Verify = %v, want two joined failures", err)
+	}
+}
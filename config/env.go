@@ -1,46 +1,450 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"shreshtasmg.in/sh_backups/license"
 	"shreshtasmg.in/sh_backups/logger"
 )
 
+const DefaultJobName = "default"
+
 type AppConfig struct {
-	APIKey          string
-	APIBaseUrl      string
+	APIKey     string
+	APIBaseUrl string
+	// LocalFolderPath is the folder of the default job.
+	LocalFolderPath string
+	// localFolderFrom names the environment variable or flag that set
+	// LocalFolderPath, if either did, for the default job in jobs.
+	localFolderFrom string
+	Network         NetworkConfig
+	// License is the verified signed license, or nil when running with an
+	// unsigned legacy license.
+	License *license.License
+	// ConfigPath is the configuration file that was loaded, if any.
+	ConfigPath string
+	Jobs       []Job
+	Retries    RetryConfig
+	Logging    LoggingConfig
+	History    HistoryConfig
+	Retention  RetentionConfig
+	Safeguards SafeguardsConfig
+	Quota      QuotaConfig
+	Staging    StagingConfig
+	Verify     VerifyConfig
+
+	Destinations []DestinationConfig
+}
+
+// NetworkConfig holds the optional proxy and TLS settings used for both the
+// backend API and the presigned S3 upload.
+type NetworkConfig struct {
+	// ProxyURL is an explicit HTTP(S) proxy. When empty the standard
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables apply.
+	ProxyURL      string `json:"proxy_url,omitempty"`
+	ProxyUsername string `json:"proxy_username,omitempty"`
+	ProxyPassword string `json:"proxy_password,omitempty"`
+	// CACertFile is a PEM bundle of extra CAs trusted on top of the system
+	// pool, e.g. a TLS-inspecting firewall's root certificate.
+	CACertFile string `json:"ca_cert_file,omitempty"`
+	// ClientCertFile and ClientKeyFile enable mutual TLS.
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+	// MinTLSVersion is "1.0", "1.1", "1.2" or "1.3"; empty means Go's default.
+	MinTLSVersion string `json:"min_tls_version,omitempty"`
+}
+
+// Overrides carries command-line flag values, the highest-precedence layer.
+type Overrides struct {
+	ConfigPath      string
+	APIBaseURL      string
 	LocalFolderPath string
+	LogLevel        string
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the license file, the JSON config file, environment variables and
+// command-line flags. Every problem found is reported in the returned error.
+func Load(overrides Overrides) (AppConfig, error) {
+	var problems []error
+	cfg := AppConfig{
+		Retries: RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: Duration{2 * time.Second},
+			MaxBackoff:     Duration{30 * time.Second},
+		},
+		Logging: LoggingConfig{
+			Level:       "info",
+			Format:      "text",
+			MaxSizeMB:   10,
+			RotateEvery: Duration{24 * time.Hour},
+			MaxBackups:  30,
+		},
+		Safeguards: SafeguardsConfig{
+			TrashRetention: Duration{30 * 24 * time.Hour},
+		},
+	}
+
+	// Layer 1: license
+	lic, legacy, err := loadLicense()
+	if err != nil {
+		problems = append(problems, err)
+	}
+	cfg.License = lic
+	if lic != nil {
+		cfg.APIKey = lic.APIKey
+		cfg.APIBaseUrl = lic.APIBaseURL
+	}
+	if err := cfg.applyEnv(func(key string) (string, bool) {
+		v, ok := legacy[key]
+		return v, ok
+	}); err != nil {
+		problems = append(problems, err)
+	}
+
+	// Layer 2: config file
+	cfg.ConfigPath = ConfigPath(overrides.ConfigPath)
+	if cfg.ConfigPath != "" {
+		fc, err := ReadFile(cfg.ConfigPath)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("config file: %w", err))
+		} else {
+			cfg.applyFile(fc)
+		}
+	}
+
+	// Layer 3: environment
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		problems = append(problems, err)
+	}
+	if os.Getenv("LOCAL_FOLDER_PATH") != "" {
+		cfg.localFolderFrom = "LOCAL_FOLDER_PATH"
+	}
+
+	// Layer 4: flags
+	cfg.applyOverrides(overrides)
+	if overrides.LocalFolderPath != "" {
+		cfg.localFolderFrom = "-local-folder"
+	}
+
+	cfg.finalize()
+	problems = append(problems, cfg.Validate()...)
+	if lic != nil && cfg.APIKey != "" {
+		if err := lic.CheckAPIKey(cfg.APIKey); err != nil {
+			problems = append(problems, fmt.Errorf("license was not issued for this API key: %w", err))
+		}
+	}
+	return cfg, errors.Join(problems...)
 }
 
-func Load() AppConfig {
+// DefaultJob returns the job used when no job is selected explicitly.
+func (cfg *AppConfig) DefaultJob() Job {
+	if len(cfg.Jobs) == 0 {
+		return Job{Name: DefaultJobName, LocalFolderPath: cfg.LocalFolderPath}
+	}
+	return cfg.Jobs[0]
+}
+
+func (cfg *AppConfig) Job(name string) (Job, bool) {
+	for _, job := range cfg.Jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+func (cfg *AppConfig) applyFile(fc *FileConfig) {
+	if fc.APIBaseURL != "" {
+		cfg.APIBaseUrl = fc.APIBaseURL
+	}
+	if fc.LocalFolderPath != "" {
+		cfg.LocalFolderPath = fc.LocalFolderPath
+	}
+	if len(fc.Jobs) > 0 {
+		cfg.Jobs = fc.Jobs
+	}
+	if fc.Retries != nil {
+		if fc.Retries.MaxAttempts != 0 {
+			cfg.Retries.MaxAttempts = fc.Retries.MaxAttempts
+		}
+		if fc.Retries.InitialBackoff.Duration != 0 {
+			cfg.Retries.InitialBackoff = fc.Retries.InitialBackoff
+		}
+		if fc.Retries.MaxBackoff.Duration != 0 {
+			cfg.Retries.MaxBackoff = fc.Retries.MaxBackoff
+		}
+	}
+	if fc.Logging != nil {
+		mergeString(&cfg.Logging.Level, fc.Logging.Level)
+		mergeString(&cfg.Logging.Format, fc.Logging.Format)
+		mergeString(&cfg.Logging.Dir, fc.Logging.Dir)
+		if fc.Logging.Console != nil {
+			cfg.Logging.Console = fc.Logging.Console
+		}
+		if fc.Logging.MaxSizeMB != 0 {
+			cfg.Logging.MaxSizeMB = fc.Logging.MaxSizeMB
+		}
+		if fc.Logging.RotateEvery.Duration != 0 {
+			cfg.Logging.RotateEvery = fc.Logging.RotateEvery
+		}
+		if fc.Logging.MaxBackups != 0 {
+			cfg.Logging.MaxBackups = fc.Logging.MaxBackups
+		}
+		if fc.Logging.Compress != nil {
+			cfg.Logging.Compress = fc.Logging.Compress
+		}
+		if fc.Logging.HTTPTrace != nil {
+			cfg.Logging.HTTPTrace = fc.Logging.HTTPTrace
+		}
+	}
+	if fc.Retention != nil {
+		cfg.Retention = *fc.Retention
+	}
+	if fc.Safeguards != nil {
+		s := &cfg.Safeguards
+		if fc.Safeguards.KeepRecentDays != 0 {
+			s.KeepRecentDays = fc.Safeguards.KeepRecentDays
+		}
+		if fc.Safeguards.Trash != nil {
+			s.Trash = fc.Safeguards.Trash
+		}
+		if fc.Safeguards.TrashRetention.Duration != 0 {
+			s.TrashRetention = fc.Safeguards.TrashRetention
+		}
+	}
+	if fc.Quota != nil {
+		mergeString(&cfg.Quota.LedgerFile, fc.Quota.LedgerFile)
+		if fc.Quota.ReconcileAfterRun != nil {
+			cfg.Quota.ReconcileAfterRun = fc.Quota.ReconcileAfterRun
+		}
+	}
+	if fc.Destinations != nil {
+		cfg.Destinations = fc.Destinations
+	}
+	if fc.Staging != nil {
+		mergeString(&cfg.Staging.Dir, fc.Staging.Dir)
+		if fc.Staging.Enabled != nil {
+			cfg.Staging.Enabled = fc.Staging.Enabled
+		}
+	}
+	if fc.Verify != nil && fc.Verify.Sample != 0 {
+		cfg.Verify.Sample = fc.Verify.Sample
+	}
+	if fc.History != nil {
+		mergeString(&cfg.History.File, fc.History.File)
+		if fc.History.ReportToBackend != nil {
+			cfg.History.ReportToBackend = fc.History.ReportToBackend
+		}
+	}
+	if fc.Network != nil {
+		n := &cfg.Network
+		mergeString(&n.ProxyURL, fc.Network.ProxyURL)
+		mergeString(&n.ProxyUsername, fc.Network.ProxyUsername)
+		mergeString(&n.ProxyPassword, fc.Network.ProxyPassword)
+		mergeString(&n.CACertFile, fc.Network.CACertFile)
+		mergeString(&n.ClientCertFile, fc.Network.ClientCertFile)
+		mergeString(&n.ClientKeyFile, fc.Network.ClientKeyFile)
+		mergeString(&n.MinTLSVersion, fc.Network.MinTLSVersion)
+	}
+}
+
+// applyEnv applies the environment-variable layer. The legacy dotenv
+// license uses the same variable names, so it is applied through here too.
+func (cfg *AppConfig) applyEnv(lookup func(string) (string, bool)) error {
+	get := func(key string) string {
+		v, _ := lookup(key)
+		return v
+	}
+	if enc := get("API_KEY_ENC"); enc != "" {
+		apiKey, err := license.DecryptAPIKey(enc)
+		if err != nil {
+			return fmt.Errorf("API_KEY_ENC: %w", err)
+		}
+		cfg.APIKey = apiKey
+	}
+	mergeString(&cfg.APIKey, get("API_KEY"))
+	mergeString(&cfg.APIBaseUrl, get("API_BASE_URL"))
+	mergeString(&cfg.LocalFolderPath, get("LOCAL_FOLDER_PATH"))
+	mergeString(&cfg.Logging.Level, get("LOG_LEVEL"))
+	mergeString(&cfg.Logging.Format, get("LOG_FORMAT"))
+	mergeString(&cfg.Logging.Dir, get("LOG_DIR"))
+	var problems []error
+	for _, flag := range []struct {
+		key string
+		dst **bool
+	}{
+		{"LOG_CONSOLE", &cfg.Logging.Console},
+		{"HTTP_TRACE", &cfg.Logging.HTTPTrace},
+	} {
+		v := get(flag.key)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: invalid boolean %q", flag.key, v))
+			continue
+		}
+		*flag.dst = &b
+	}
+
+	n := &cfg.Network
+	mergeString(&n.ProxyURL, get("PROXY_URL"))
+	mergeString(&n.ProxyUsername, get("PROXY_USERNAME"))
+	mergeString(&n.ProxyPassword, get("PROXY_PASSWORD"))
+	mergeString(&n.CACertFile, get("CA_CERT_FILE"))
+	mergeString(&n.ClientCertFile, get("CLIENT_CERT_FILE"))
+	mergeString(&n.ClientKeyFile, get("CLIENT_KEY_FILE"))
+	mergeString(&n.MinTLSVersion, get("TLS_MIN_VERSION"))
+	return errors.Join(problems...)
+}
+
+func (cfg *AppConfig) applyOverrides(o Overrides) {
+	mergeString(&cfg.APIBaseUrl, o.APIBaseURL)
+	mergeString(&cfg.LocalFolderPath, o.LocalFolderPath)
+	mergeString(&cfg.Logging.Level, o.LogLevel)
+}
+
+// finalize expands paths, and only paths, and fills in the implicit default
+// job.
+func (cfg *AppConfig) finalize() {
+	cfg.LocalFolderPath = ExpandPath(cfg.LocalFolderPath)
+	cfg.Logging.Dir = ExpandPath(cfg.Logging.Dir)
+	cfg.History.File = ExpandPath(cfg.History.File)
+	cfg.Quota.LedgerFile = ExpandPath(cfg.Quota.LedgerFile)
+	cfg.Staging.Dir = ExpandPath(cfg.Staging.Dir)
+	for i := range cfg.Jobs {
+		if m := cfg.Jobs[i].Mirror; m != nil {
+			m.Path = ExpandPath(m.Path)
+		}
+	}
+	for i := range cfg.Destinations {
+		d := &cfg.Destinations[i]
+		d.Path = ExpandPath(d.Path)
+		d.AccessKeyID = expandSecret(d.AccessKeyID)
+		d.SecretAccessKey = expandSecret(d.SecretAccessKey)
+		if d.Type == DestinationS3 && d.Region == "" {
+			d.Region = "us-east-1"
+		}
+	}
+	cfg.Network.CACertFile = ExpandPath(cfg.Network.CACertFile)
+	cfg.Network.ClientCertFile = ExpandPath(cfg.Network.ClientCertFile)
+	cfg.Network.ClientKeyFile = ExpandPath(cfg.Network.ClientKeyFile)
+
+	if len(cfg.Jobs) == 0 && cfg.LocalFolderPath != "" {
+		cfg.Jobs = []Job{cfg.DefaultJob()}
+	}
+	// A folder from the environment or a flag outranks the config file, so
+	// it replaces the folder of the file's default job. Validate reports it
+	// when the file has no such job.
+	if cfg.localFolderFrom != "" {
+		for i := range cfg.Jobs {
+			if cfg.Jobs[i].Name == DefaultJobName {
+				cfg.Jobs[i].LocalFolderPath = cfg.LocalFolderPath
+			}
+		}
+	}
+	for i := range cfg.Jobs {
+		cfg.Jobs[i].LocalFolderPath = ExpandPath(cfg.Jobs[i].LocalFolderPath)
+	}
+	if cfg.LocalFolderPath == "" && len(cfg.Jobs) > 0 {
+		cfg.LocalFolderPath = cfg.Jobs[0].LocalFolderPath
+	}
+}
+
+// expandSecret replaces a credential given as a whole ${VAR} reference with
+// that environment variable. Any other value is used as it is, so a secret
+// containing $ or % is never altered.
+func expandSecret(v string) string {
+	if name, ok := strings.CutPrefix(v, "${"); ok && strings.HasSuffix(name, "}") {
+		return os.Getenv(strings.TrimSuffix(name, "}"))
+	}
+	return v
+}
+
+func mergeString(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
+
+// LicensePath returns apikey.lic in the user's home directory, falling back
+// to the current directory.
+func LicensePath() string {
 	homeDir, err := os.UserHomeDir()
-	var licPath string
 	if err != nil {
-		logger.Error("Could not get user home directory: %v", err)
+		logger.Error("Could not get user home directory", err)
 	}
-	licPath = filepath.Join(homeDir, "apikey.lic")
-	_, err = os.Stat(licPath)
-	if os.IsNotExist(err) {
+	licPath := filepath.Join(homeDir, "apikey.lic")
+	if _, err := os.Stat(licPath); os.IsNotExist(err) {
 		licPath = "apikey.lic"
 	}
+	return licPath
+}
 
-	_ = godotenv.Load(licPath)
-
-	return AppConfig{
-		APIKey:          must("API_KEY"),
-		APIBaseUrl:      must("API_BASE_URL"),
-		LocalFolderPath: must("LOCAL_FOLDER_PATH"),
+// loadLicense returns the verified signed license or, when unsigned
+// licenses are allowed, the variables of a legacy dotenv apikey.lic.
+func loadLicense() (*license.License, map[string]string, error) {
+	licPath := LicensePath()
+	data, err := os.ReadFile(licPath)
+	switch {
+	case err == nil && license.IsSigned(data):
+		lic, err := loadSignedLicense(licPath, data)
+		return lic, nil, err
+	case AllowUnsignedLicense():
+		if err != nil {
+			return nil, nil, nil
+		}
+		logger.Warn("Using unsigned legacy license", "license", licPath)
+		legacy, err := godotenv.UnmarshalBytes(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("license %s: %w", licPath, err)
+		}
+		return nil, legacy, nil
+	case err != nil:
+		return nil, nil, fmt.Errorf("license file not found at %s; place the signed apikey.lic in your home directory: %w", licPath, err)
+	default:
+		return nil, nil, fmt.Errorf("%s is an unsigned legacy license; request a signed license", licPath)
 	}
 }
 
-func must(key string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		logger.Error(fmt.Sprintf("Missing env var: %s", key), nil)
-		os.Exit(1)
+func loadSignedLicense(licPath string, data []byte) (*license.License, error) {
+	pub, err := license.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify license: %w", err)
 	}
-	return val
+	lic, err := license.Verify(data, pub, time.Now())
+	switch {
+	case errors.Is(err, license.ErrExpired):
+		return nil, fmt.Errorf("license %s has expired; contact support to renew it: %w", licPath, err)
+	case errors.Is(err, license.ErrDecryptAPIKey):
+		return nil, fmt.Errorf("license %s holds an API key encrypted for another machine; reinstall the license and run 'sh-backups license encrypt': %w", licPath, err)
+	case errors.Is(err, license.ErrInvalidSignature):
+		return nil, fmt.Errorf("license %s failed verification; restore the original file issued to you: %w", licPath, err)
+	case err != nil:
+		return nil, fmt.Errorf("license %s is invalid: %w", licPath, err)
+	}
+	logger.Info("License verified", "license", licPath, "company_id", lic.CompanyID, "expires", lic.ExpiresAt.Format(time.DateOnly))
+	return lic, nil
+}
+
+// allowUnsigned accepts legacy dotenv licenses, or no license at all. It
+// can only be set at build time, for development builds:
+//
+//	go build -ldflags "-X shreshtasmg.in/sh_backups/config.allowUnsigned=true"
+var allowUnsigned string
+
+// AllowUnsignedLicense reports whether this build accepts legacy dotenv
+// licenses, or no license at all.
+func AllowUnsignedLicense() bool {
+	allow, _ := strconv.ParseBool(allowUnsigned)
+	return allow
 }
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// isolate points Load at an empty home directory, clears the variables it
+// reads and lets it accept unsigned licenses, as a development build does.
+func isolate(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	for _, key := range []string{
+		"SH_BACKUPS_CONFIG", "API_KEY", "API_KEY_ENC", "API_BASE_URL", "LOCAL_FOLDER_PATH",
+		"LOG_LEVEL", "LOG_FORMAT", "LOG_DIR", "LOG_CONSOLE", "HTTP_TRACE",
+		"PROXY_URL", "PROXY_USERNAME", "PROXY_PASSWORD", "CA_CERT_FILE", "CLIENT_CERT_FILE", "CLIENT_KEY_FILE", "TLS_MIN_VERSION",
+	} {
+		t.Setenv(key, "")
+	}
+	previous := allowUnsigned
+	allowUnsigned = "true"
+	t.Cleanup(func() { allowUnsigned = previous })
+	return home
+}
+
+func writeFile(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRequiresSignedLicense(t *testing.T) {
+	home := isolate(t)
+	allowUnsigned = ""
+	writeFile(t, filepath.Join(home, "apikey.lic"), "API_KEY=key\nAPI_BASE_URL=https://api.example.com\n")
+	t.Setenv("LOCAL_FOLDER_PATH", "/tally")
+
+	_, err := Load(Overrides{})
+	if err == nil || !strings.Contains(err.Error(), "unsigned legacy license") {
+		t.Errorf("Load = %v, want the unsigned license rejected", err)
+	}
+}
+
+// Licensed features are refused when there is no signed license at all.
+func TestLoadDeniesFeaturesWithoutLicense(t *testing.T) {
+	home := isolate(t)
+	configPath := writeFile(t, filepath.Join(home, "config.json"), `{
+		"jobs": [{"name": "tally", "local_folder_path": "/tally", "mirror": {"path": "/nas"}}]
+	}`)
+	t.Setenv("API_KEY", "key")
+	t.Setenv("API_BASE_URL", "https://api.example.com")
+
+	_, err := Load(Overrides{ConfigPath: configPath})
+	if err == nil || !strings.Contains(err.Error(), "jobs[0].mirror: the license does not include the mirror feature") {
+		t.Errorf("Load = %v, want the mirror refused", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	home := isolate(t)
+	writeFile(t, filepath.Join(home, "apikey.lic"), strings.Join([]string{
+		"API_KEY=license-key",
+		"API_BASE_URL=https://license.example.com",
+		"LOCAL_FOLDER_PATH=/from/license",
+		"LOG_LEVEL=warn",
+		"LOG_FORMAT=json",
+	}, "\n"))
+	configPath := writeFile(t, filepath.Join(home, "config.json"), `{
+		"api_base_url": "https://file.example.com",
+		"local_folder_path": "/from/file",
+		"logging": {"level": "error", "dir": "~/logs"}
+	}`)
+	t.Setenv("LOCAL_FOLDER_PATH", "/from/env")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	cfg, err := Load(Overrides{ConfigPath: configPath, LogLevel: "info"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	tests := []struct {
+		field, got, want string
+	}{
+		{"api key (license only)", cfg.APIKey, "license-key"},
+		{"log format (license only)", cfg.Logging.Format, "json"},
+		{"api base url (file over license)", cfg.APIBaseUrl, "https://file.example.com"},
+		{"local folder (env over file)", cfg.LocalFolderPath, "/from/env"},
+		{"log level (flag over env)", cfg.Logging.Level, "info"},
+		{"log dir (expanded)", cfg.Logging.Dir, filepath.Join(home, "logs")},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, tt.got, tt.want)
+		}
+	}
+	if len(cfg.Jobs) != 1 || cfg.Jobs[0].Name != DefaultJobName || cfg.Jobs[0].LocalFolderPath != "/from/env" {
+		t.Errorf("jobs = %+v, want the default job on /from/env", cfg.Jobs)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	home := isolate(t)
+	configPath := writeFile(t, filepath.Join(home, "config.json"), `{
+		"api_base_url": "ftp://example.com",
+		"jobs": [
+			{"name": "tally", "local_folder_path": "/tally",
+			 "schedules": [{"operation": "backup", "every": "1h"}],
+			 "mirror": {"path": "/nas"}},
+			{"name": "tally", "local_folder_path": "/other"}
+		],
+		"retries": {"max_attempts": 20},
+		"logging": {"level": "loud"},
+		"retention": {"keep_last": -1}
+	}`)
+	t.Setenv("API_KEY", "key")
+	t.Setenv("HTTP_TRACE", "sometimes")
+
+	_, err := Load(Overrides{ConfigPath: configPath})
+	if err == nil {
+		t.Fatal("Load accepted an invalid configuration")
+	}
+	for _, want := range []string{
+		`HTTP_TRACE: invalid boolean "sometimes"`,
+		`api_base_url: "ftp://example.com" is not an http(s) URL`,
+		`jobs[0].schedules[0].operation: "backup" must be one of`,
+		"jobs[0].mirror: the license does not include the mirror feature",
+		`jobs[1].name: duplicate job name "tally"`,
+		"retries.max_attempts: 20 must be between 1 and 10",
+		`logging.level: "loud" must be one of`,
+		"retention: keep_* counts must not be negative",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	home := isolate(t)
+	configPath := writeFile(t, filepath.Join(home, "config.json"), `{"local_folder_path": "/tally", "retension": {}}`)
+	t.Setenv("API_KEY", "key")
+	t.Setenv("API_BASE_URL", "https://api.example.com")
+
+	_, err := Load(Overrides{ConfigPath: configPath})
+	if err == nil || !strings.Contains(err.Error(), "retension") {
+		t.Errorf("Load = %v, want an error naming the unknown field", err)
+	}
+}
+
+func TestDestinationSecretsAreNotExpanded(t *testing.T) {
+	isolate(t)
+	t.Setenv("MINIO_SECRET", "from-env")
+	cfg := AppConfig{Destinations: []DestinationConfig{
+		{Name: "literal", Type: DestinationS3, AccessKeyID: "AK$ID", SecretAccessKey: "p%ss$word"},
+		{Name: "reference", Type: DestinationS3, AccessKeyID: "AKID", SecretAccessKey: "${MINIO_SECRET}"},
+	}}
+	cfg.finalize()
+	if d := cfg.Destinations[0]; d.AccessKeyID != "AK$ID" || d.SecretAccessKey != "p%ss$word" {
+		t.Errorf("literal credentials changed to %q, %q", d.AccessKeyID, d.SecretAccessKey)
+	}
+	if got := cfg.Destinations[1].SecretAccessKey; got != "from-env" {
+		t.Errorf("${MINIO_SECRET} = %q, want from-env", got)
+	}
+}
+
+// A folder from the environment or a flag replaces the config file's
+// default job folder, and is an error when the file has no default job.
+func TestLocalFolderOverridesJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		jobs    string
+		env     string
+		flag    string
+		folders map[string]string
+		err     string
+	}{
+		{
+			name:    "file only",
+			jobs:    `[{"name": "default", "local_folder_path": "/file"}]`,
+			folders: map[string]string{"default": "/file"},
+		},
+		{
+			name:    "env over the default job",
+			jobs:    `[{"name": "default", "local_folder_path": "/file"}, {"name": "other", "local_folder_path": "/other"}]`,
+			env:     "/from/env",
+			folders: map[string]string{"default": "/from/env", "other": "/other"},
+		},
+		{
+			name:    "flag over env",
+			jobs:    `[{"name": "default", "local_folder_path": "/file"}]`,
+			env:     "/from/env",
+			flag:    "/from/flag",
+			folders: map[string]string{"default": "/from/flag"},
+		},
+		{
+			name: "env without a default job",
+			jobs: `[{"name": "tally", "local_folder_path": "/tally"}]`,
+			env:  "/from/env",
+			err:  `LOCAL_FOLDER_PATH: "/from/env" cannot apply to the jobs in the config file`,
+		},
+		{
+			name: "flag without a default job",
+			jobs: `[{"name": "tally", "local_folder_path": "/tally"}]`,
+			flag: "/from/flag",
+			err:  `-local-folder: "/from/flag" cannot apply to the jobs in the config file`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := isolate(t)
+			configPath := writeFile(t, filepath.Join(home, "config.json"), `{"local_folder_path": "/top", "jobs": `+tt.jobs+`}`)
+			t.Setenv("API_KEY", "key")
+			t.Setenv("API_BASE_URL", "https://api.example.com")
+			t.Setenv("LOCAL_FOLDER_PATH", tt.env)
+
+			cfg, err := Load(Overrides{ConfigPath: configPath, LocalFolderPath: tt.flag})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Load = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			for name, want := range tt.folders {
+				if job, _ := cfg.Job(name); job.LocalFolderPath != want {
+					t.Errorf("job %s folder = %q, want %q", name, job.LocalFolderPath, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileConfig is the schema of the JSON configuration file. Every section is
+// optional; see config.example.json for a documented example.
+type FileConfig struct {
+	APIBaseURL string `json:"api_base_url,omitempty"`
+	// LocalFolderPath is the folder of the implicit "default" job used when
+	// Jobs is empty.
+	LocalFolderPath string            `json:"local_folder_path,omitempty"`
+	Jobs            []Job             `json:"jobs,omitempty"`
+	Retries         *RetryConfig      `json:"retries,omitempty"`
+	Logging         *LoggingConfig    `json:"logging,omitempty"`
+	Network         *NetworkConfig    `json:"network,omitempty"`
+	History         *HistoryConfig    `json:"history,omitempty"`
+	Retention       *RetentionConfig  `json:"retention,omitempty"`
+	Safeguards      *SafeguardsConfig `json:"safeguards,omitempty"`
+	Quota           *QuotaConfig      `json:"quota,omitempty"`
+	Staging         *StagingConfig    `json:"staging,omitempty"`
+	Verify          *VerifyConfig     `json:"verify,omitempty"`
+	// Destinations are the places, besides the backend, that jobs can copy
+	// their backups to.
+	Destinations []DestinationConfig `json:"destinations,omitempty"`
+}
+
+// Job is one backup source and the schedules it runs on in daemon mode.
+type Job struct {
+	Name            string     `json:"name"`
+	LocalFolderPath string     `json:"local_folder_path"`
+	Schedules       []Schedule `json:"schedules,omitempty"`
+	// Destinations names the destinations each upload is also copied to,
+	// after the backend.
+	Destinations []string `json:"destinations,omitempty"`
+	// Mirror keeps a local copy of every uploaded backup.
+	Mirror *MirrorConfig `json:"mirror,omitempty"`
+	// Chunked stores backups as deduplicated content-defined chunks, so
+	// each upload sends only what changed since the previous backups.
+	Chunked bool `json:"chunked,omitempty"`
+}
+
+// MirrorConfig keeps a copy of every uploaded backup on a mounted path,
+// such as a NAS share, pruned by its own retention policy.
+type MirrorConfig struct {
+	Path      string          `json:"path"`
+	Retention RetentionConfig `json:"retention,omitempty"`
+}
+
+// Schedule runs Operation either every Every, or daily at At ("HH:MM",
+// local time).
+type Schedule struct {
+	Operation string   `json:"operation"`
+	Every     Duration `json:"every,omitempty"`
+	At        string   `json:"at,omitempty"`
+}
+
+// RetryConfig controls retries of failed backend and S3 requests.
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	InitialBackoff Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`
+}
+
+type LoggingConfig struct {
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+	Dir    string `json:"dir,omitempty"`
+	// Console mirrors log records to the terminal; it defaults to true.
+	Console     *bool    `json:"console,omitempty"`
+	MaxSizeMB   int      `json:"max_size_mb,omitempty"`
+	RotateEvery Duration `json:"rotate_every,omitempty"`
+	MaxBackups  int      `json:"max_backups,omitempty"`
+	Compress    *bool    `json:"compress,omitempty"`
+	// HTTPTrace logs every API and S3 exchange at debug level.
+	HTTPTrace *bool `json:"http_trace,omitempty"`
+}
+
+func (l LoggingConfig) ConsoleEnabled() bool {
+	return l.Console == nil || *l.Console
+}
+
+func (l LoggingConfig) HTTPTraceEnabled() bool {
+	return l.HTTPTrace != nil && *l.HTTPTrace
+}
+
+// RetentionConfig decides which remote backups the delete operation keeps.
+// When no field is set, delete empties the folder once the quota is reached.
+type RetentionConfig struct {
+	KeepLast    int      `json:"keep_last,omitempty"`
+	KeepDaily   int      `json:"keep_daily,omitempty"`
+	KeepWeekly  int      `json:"keep_weekly,omitempty"`
+	KeepMonthly int      `json:"keep_monthly,omitempty"`
+	MaxAge      Duration `json:"max_age,omitempty"`
+}
+
+// SafeguardsConfig protects remote backups from accidental deletion.
+type SafeguardsConfig struct {
+	// KeepRecentDays refuses any delete that would leave no backup newer
+	// than this many days; 0 disables.
+	KeepRecentDays int `json:"keep_recent_days,omitempty"`
+	// Trash moves deleted backups to a trash prefix instead of deleting them.
+	Trash *bool `json:"trash,omitempty"`
+	// TrashRetention is how long trashed backups stay recoverable.
+	TrashRetention Duration `json:"trash_retention,omitempty"`
+}
+
+func (s SafeguardsConfig) TrashEnabled() bool {
+	return s.Trash != nil && *s.Trash
+}
+
+// QuotaConfig controls how the company's used quota is kept accurate.
+type QuotaConfig struct {
+	// ReconcileAfterRun compares the used quota with the remote folder after
+	// every run and corrects it; it defaults to true.
+	ReconcileAfterRun *bool `json:"reconcile_after_run,omitempty"`
+	// LedgerFile is the local record of stored backups; empty means
+	// ~/.sh_backups/ledger.json.
+	LedgerFile string `json:"ledger_file,omitempty"`
+}
+
+func (q QuotaConfig) ReconcileEnabled() bool {
+	return q.ReconcileAfterRun == nil || *q.ReconcileAfterRun
+}
+
+// Destination types.
+const (
+	DestinationS3         = "s3"
+	DestinationFilesystem = "filesystem"
+)
+
+// DestinationConfig describes a named upload destination. An "s3"
+// destination uses Endpoint through PathStyle; a "filesystem" destination
+// uses Path.
+type DestinationConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Path is the directory backups are copied to, e.g. a mounted NAS share.
+	Path string `json:"path,omitempty"`
+	// Endpoint is the S3-compatible service URL; Region defaults to
+	// us-east-1.
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	PathStyle       bool   `json:"path_style,omitempty"`
+}
+
+// Destination returns the destination named name.
+func (cfg AppConfig) Destination(name string) (DestinationConfig, bool) {
+	for _, d := range cfg.Destinations {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return DestinationConfig{}, false
+}
+
+// StagingConfig controls the snapshot taken of a backup before it is
+// uploaded.
+type StagingConfig struct {
+	// Enabled copies the backup to Dir and uploads the copy; it defaults to
+	// true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Dir holds the snapshots; empty means a staging folder in the user's
+	// cache directory.
+	Dir string `json:"dir,omitempty"`
+}
+
+func (s StagingConfig) StagingEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// VerifyConfig controls which backups a verify run test-restores.
+type VerifyConfig struct {
+	// Sample verifies this many backups picked at random; 0 verifies only
+	// the latest backup.
+	Sample int `json:"sample,omitempty"`
+}
+
+// HistoryConfig controls where run summaries are kept and whether they are
+// reported to the backend.
+type HistoryConfig struct {
+	// File is the JSON Lines history file; empty means
+	// ~/.sh_backups/history.jsonl.
+	File string `json:"file,omitempty"`
+	// ReportToBackend also posts each run summary to the backend.
+	ReportToBackend *bool `json:"report_to_backend,omitempty"`
+}
+
+func (h HistoryConfig) ReportEnabled() bool {
+	return h.ReportToBackend != nil && *h.ReportToBackend
+}
+
+// Duration is a time.Duration written as a Go duration string ("90s", "6h").
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\" or \"6h\"")
+	}
+	v, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// ParseDuration parses a Go duration string or a number of whole days
+// ("30d", accepted for retention ages). An empty string is 0.
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ConfigPath returns the configuration file to use: the explicit path if
+// given, then $SH_BACKUPS_CONFIG, then ~/.sh_backups/config.json or
+// ./sh_backups.json if either exists. It returns "" when there is none.
+func ConfigPath(explicit string) string {
+	if explicit != "" {
+		return ExpandPath(explicit)
+	}
+	if env := os.Getenv("SH_BACKUPS_CONFIG"); env != "" {
+		return ExpandPath(env)
+	}
+	candidates := []string{"sh_backups.json"}
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		candidates = append([]string{filepath.Join(homeDir, ".sh_backups", "config.json")}, candidates...)
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// ReadFile parses a configuration file, rejecting unknown fields so typos
+// are reported instead of silently ignored.
+func ReadFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var fc FileConfig
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &fc, nil
+}
+
+var windowsEnvVar = regexp.MustCompile(`%([A-Za-z_][A-Za-z0-9_]*)%`)
+
+// ExpandPath expands a leading ~ to the home directory and $VAR, ${VAR} and
+// %VAR% references to environment variables.
+func ExpandPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	path = windowsEnvVar.ReplaceAllStringFunc(path, func(m string) string {
+		if v, ok := os.LookupEnv(m[1 : len(m)-1]); ok {
+			return v
+		}
+		return m
+	})
+	path = os.ExpandEnv(path)
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, path[1:])
+		}
+	}
+	return path
+}
+
+// Next returns the first time after `after` at which the schedule is due.
+func (s Schedule) Next(after time.Time) time.Time {
+	if s.Every.Duration > 0 {
+		return after.Add(s.Every.Duration)
+	}
+	at, err := time.Parse("15:04", s.At)
+	if err != nil {
+		return time.Time{}
+	}
+	next := time.Date(after.Year(), after.Month(), after.Day(), at.Hour(), at.Minute(), 0, 0, after.Location())
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Validate checks the merged configuration and returns every problem found
+// rather than stopping at the first.
+func (cfg *AppConfig) Validate() []error {
+	var problems []error
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if cfg.APIKey == "" {
+		add("api key: missing; install a license or set API_KEY")
+	}
+	if cfg.APIBaseUrl == "" {
+		add("api_base_url: missing; set it in the license, config file, API_BASE_URL or -api-base-url")
+	} else if u, err := url.Parse(cfg.APIBaseUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("api_base_url: %q is not an http(s) URL", cfg.APIBaseUrl)
+	}
+
+	if len(cfg.Jobs) == 0 {
+		add("jobs: no backup folder configured; set local_folder_path, LOCAL_FOLDER_PATH or -local-folder, or define jobs")
+	} else if _, ok := cfg.Job(DefaultJobName); cfg.localFolderFrom != "" && !ok {
+		add("%s: %q cannot apply to the jobs in the config file, which has no %q job; set jobs[].local_folder_path instead", cfg.localFolderFrom, cfg.LocalFolderPath, DefaultJobName)
+	}
+	seen := map[string]bool{}
+	for i, job := range cfg.Jobs {
+		field := fmt.Sprintf("jobs[%d]", i)
+		if job.Name == "" {
+			add("%s.name: missing", field)
+		} else if seen[job.Name] {
+			add("%s.name: duplicate job name %q", field, job.Name)
+		}
+		seen[job.Name] = true
+		if job.LocalFolderPath == "" {
+			add("%s.local_folder_path: missing", field)
+		}
+		for j, sched := range job.Schedules {
+			problems = append(problems, validateSchedule(fmt.Sprintf("%s.schedules[%d]", field, j), sched)...)
+			if job.Chunked && sched.Operation == "rotate" {
+				add("%s.schedules[%d].operation: rotate is not supported for a chunked job", field, j)
+			}
+			if sched.Operation == "verify" && !cfg.License.HasFeature("verify") {
+				add("%s.schedules[%d].operation: the license does not include the verify feature", field, j)
+			}
+		}
+		if m := job.Mirror; m != nil {
+			if m.Path == "" {
+				add("%s.mirror.path: missing", field)
+			}
+			problems = append(problems, validateRetention(field+".mirror.retention", m.Retention)...)
+			if !cfg.License.HasFeature("mirror") {
+				add("%s.mirror: the license does not include the mirror feature", field)
+			}
+		}
+		for j, name := range job.Destinations {
+			if _, ok := cfg.Destination(name); !ok {
+				add("%s.destinations[%d]: no destination named %q", field, j, name)
+			}
+		}
+	}
+
+	seenDest := map[string]bool{}
+	for i, d := range cfg.Destinations {
+		field := fmt.Sprintf("destinations[%d]", i)
+		switch {
+		case d.Name == "":
+			add("%s.name: missing", field)
+		case d.Name == "backend":
+			add("%s.name: \"backend\" is reserved for the backend upload every job already does", field)
+		case seenDest[d.Name]:
+			add("%s.name: duplicate destination name %q", field, d.Name)
+		}
+		seenDest[d.Name] = true
+		switch d.Type {
+		case DestinationFilesystem:
+			if d.Path == "" {
+				add("%s.path: missing", field)
+			}
+		case DestinationS3:
+			if u, err := url.Parse(d.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("%s.endpoint: %q is not an http(s) URL", field, d.Endpoint)
+			}
+			if d.Bucket == "" {
+				add("%s.bucket: missing", field)
+			}
+			if d.AccessKeyID == "" || d.SecretAccessKey == "" {
+				add("%s: access_key_id and secret_access_key are required", field)
+			}
+		default:
+			add("%s.type: %q must be s3 or filesystem", field, d.Type)
+		}
+	}
+
+	r := cfg.Retries
+	if r.MaxAttempts < 1 || r.MaxAttempts > 10 {
+		add("retries.max_attempts: %d must be between 1 and 10", r.MaxAttempts)
+	}
+	if r.InitialBackoff.Duration < 0 || r.MaxBackoff.Duration < 0 {
+		add("retries: backoff durations must not be negative")
+	} else if r.InitialBackoff.Duration > r.MaxBackoff.Duration {
+		add("retries.initial_backoff: %s is larger than max_backoff %s", r.InitialBackoff, r.MaxBackoff)
+	}
+
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		add("logging.level: %q must be one of debug, info, warn, error", cfg.Logging.Level)
+	}
+	switch cfg.Logging.Format {
+	case "text", "json":
+	default:
+		add("logging.format: %q must be text or json", cfg.Logging.Format)
+	}
+	if cfg.Logging.HTTPTraceEnabled() && cfg.Logging.Level != "debug" {
+		add("logging.http_trace: requires logging.level debug (got %q)", cfg.Logging.Level)
+	}
+	if cfg.Logging.MaxSizeMB < 0 {
+		add("logging.max_size_mb: %d must not be negative", cfg.Logging.MaxSizeMB)
+	}
+	if cfg.Logging.MaxBackups < 0 {
+		add("logging.max_backups: %d must not be negative", cfg.Logging.MaxBackups)
+	}
+	if d := cfg.Logging.RotateEvery.Duration; d != 0 && d < time.Hour {
+		add("logging.rotate_every: %s is shorter than one hour", cfg.Logging.RotateEvery)
+	}
+
+	problems = append(problems, validateRetention("retention", cfg.Retention)...)
+
+	if cfg.Verify.Sample < 0 {
+		add("verify.sample: %d must not be negative", cfg.Verify.Sample)
+	}
+	if cfg.Safeguards.KeepRecentDays < 0 {
+		add("safeguards.keep_recent_days: %d must not be negative", cfg.Safeguards.KeepRecentDays)
+	}
+	if d := cfg.Safeguards.TrashRetention.Duration; d < 24*time.Hour {
+		add("safeguards.trash_retention: %s is shorter than one day", cfg.Safeguards.TrashRetention)
+	}
+
+	n := cfg.Network
+	if n.ProxyURL != "" {
+		if u, err := url.Parse(n.ProxyURL); err != nil || u.Host == "" {
+			add("network.proxy_url: %q is not a valid URL", n.ProxyURL)
+		}
+	}
+	if (n.ClientCertFile == "") != (n.ClientKeyFile == "") {
+		add("network: client_cert_file and client_key_file must be set together")
+	}
+	switch n.MinTLSVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		add("network.min_tls_version: %q must be 1.0, 1.1, 1.2 or 1.3", n.MinTLSVersion)
+	}
+	return problems
+}
+
+// Operations a schedule can run.
+var scheduleOperations = []string{"upload", "delete", "rotate", "verify"}
+
+func validateSchedule(field string, s Schedule) []error {
+	var problems []error
+	valid := false
+	for _, op := range scheduleOperations {
+		valid = valid || s.Operation == op
+	}
+	if !valid {
+		problems = append(problems, fmt.Errorf("%s.operation: %q must be one of %s", field, s.Operation, strings.Join(scheduleOperations, ", ")))
+	}
+	switch {
+	case s.Every.Duration == 0 && s.At == "":
+		problems = append(problems, fmt.Errorf("%s: set either every or at", field))
+	case s.Every.Duration != 0 && s.At != "":
+		problems = append(problems, fmt.Errorf("%s: every and at are mutually exclusive", field))
+	case s.Every.Duration != 0 && s.Every.Duration < time.Minute:
+		problems = append(problems, fmt.Errorf("%s.every: %s is shorter than one minute", field, s.Every))
+	case s.At != "":
+		if _, err := time.Parse("15:04", s.At); err != nil {
+			problems = append(problems, fmt.Errorf("%s.at: %q is not a valid HH:MM time", field, s.At))
+		}
+	}
+	return problems
+}
+
+func validateRetention(field string, r RetentionConfig) []error {
+	var problems []error
+	if r.KeepLast < 0 || r.KeepDaily < 0 || r.KeepWeekly < 0 || r.KeepMonthly < 0 {
+		problems = append(problems, fmt.Errorf("%s: keep_* counts must not be negative", field))
+	}
+	if r.MaxAge.Duration < 0 {
+		problems = append(problems, fmt.Errorf("%s.max_age: %s must not be negative", field, r.MaxAge))
+	} else if r.MaxAge.Duration > 0 && r.MaxAge.Duration < 24*time.Hour {
+		problems = append(problems, fmt.Errorf("%s.max_age: %s is shorter than one day", field, r.MaxAge))
+	}
+	return problems
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/runid"
+)
+
+type scheduledRun struct {
+	job      config.Job
+	schedule config.Schedule
+	next     time.Time
+}
+
+// runDaemon stays in the foreground and runs each job's schedules until
+// interrupted. Runs never overlap; a run that is due while another is in
+// progress starts as soon as that one finishes.
+func runDaemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	overrides := configFlags(fs)
+	_ = fs.Parse(args)
+
+	cfg := loadConfig(*overrides)
+	apiClient := newAPIClient(cfg)
+
+	var runs []*scheduledRun
+	now := time.Now()
+	for _, job := range cfg.Jobs {
+		for _, sched := range job.Schedules {
+			runs = append(runs, &scheduledRun{job: job, schedule: sched, next: sched.Next(now)})
+		}
+	}
+	if len(runs) == 0 {
+		fmt.Fprintln(os.Stderr, "No schedules configured; add schedules to the jobs in the config file")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger.Info("Daemon started", "schedules", len(runs))
+
+	for {
+		due := runs[0]
+		for _, r := range runs[1:] {
+			if r.next.Before(due.next) {
+				due = r
+			}
+		}
+		logger.Info("Next scheduled run", "operation", due.schedule.Operation, "job", due.job.Name, "at", due.next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(due.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("Daemon stopped")
+			return
+		case <-timer.C:
+		}
+
+		// Every scheduled run gets its own run ID and history record.
+		runid.StartRun()
+		_ = runJobs(cfg, apiClient, []config.Job{due.job}, due.schedule.Operation)
+		runid.EndRun()
+		due.next = due.schedule.Next(time.Now())
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/backup"
+	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/license"
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/runid"
+	"shreshtasmg.in/sh_backups/utils"
+)
+
+const (
+	maxClockSkew  = 5 * time.Minute
+	staleBackup   = 48 * time.Hour
+	amzDateFormat = "20060102T150405Z"
+)
+
+type checkStatus string
+
+const (
+	statusPass checkStatus = "PASS"
+	statusWarn checkStatus = "WARN"
+	statusFail checkStatus = "FAIL"
+	statusSkip checkStatus = "SKIP"
+)
+
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+	hint   string
+}
+
+// doctor runs the installation checks support would otherwise walk a
+// customer through, collecting results instead of stopping at the first
+// failure.
+type doctor struct {
+	results []checkResult
+}
+
+func (d *doctor) report(name string, status checkStatus, detail, hint string) {
+	d.results = append(d.results, checkResult{name: name, status: status, detail: detail, hint: hint})
+}
+
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	overrides := configFlags(fs)
+	_ = fs.Parse(args)
+	runid.StartRun()
+
+	d := &doctor{}
+	d.checkLicense()
+	cfg, cfgErr := config.Load(*overrides)
+	d.checkConfig(cfg, cfgErr)
+	latest := d.checkBackups(cfg)
+	client, company := d.checkAPI(cfg)
+	d.checkClock(client, company, latest)
+	d.checkLogs(cfg)
+
+	failed := false
+	for _, r := range d.results {
+		fmt.Printf("[%s] %s: %s\n", r.status, r.name, r.detail)
+		if r.hint != "" && r.status != statusPass {
+			fmt.Printf("       -> %s\n", r.hint)
+		}
+		failed = failed || r.status == statusFail
+	}
+	if failed {
+		fmt.Println("\nSome checks failed; fix the items marked FAIL and run 'sh-backups doctor' again.")
+		os.Exit(1)
+	}
+	fmt.Println("\nAll checks passed.")
+}
+
+func (d *doctor) checkLicense() {
+	const name = "License"
+	licPath := config.LicensePath()
+	data, err := os.ReadFile(licPath)
+	if err != nil && config.AllowUnsignedLicense() {
+		d.report(name, statusWarn, fmt.Sprintf("not found at %s; running unlicensed because this build allows unsigned licenses", licPath),
+			"Install the signed apikey.lic you were issued.")
+		return
+	}
+	if err != nil {
+		d.report(name, statusFail, fmt.Sprintf("not found at %s", licPath),
+			"Copy the apikey.lic you were issued into your home directory.")
+		return
+	}
+	if !license.IsSigned(data) {
+		d.report(name, statusWarn, fmt.Sprintf("%s is an unsigned legacy license", licPath),
+			"Request a signed license; legacy licenses only work with development builds.")
+		return
+	}
+	pub, err := license.PublicKey()
+	if err != nil {
+		d.report(name, statusFail, err.Error(), "This build is broken; reinstall sh-backups.")
+		return
+	}
+	lic, err := license.Verify(data, pub, time.Now())
+	switch {
+	case errors.Is(err, license.ErrExpired):
+		d.report(name, statusFail, err.Error(), "Contact support to renew the license.")
+	case errors.Is(err, license.ErrInvalidSignature):
+		d.report(name, statusFail, err.Error(), "The file was modified; restore the original apikey.lic.")
+	case errors.Is(err, license.ErrDecryptAPIKey):
+		d.report(name, statusFail, err.Error(), "Reinstall the license on this machine and run 'sh-backups license encrypt'.")
+	case err != nil:
+		d.report(name, statusFail, err.Error(), "Reinstall the apikey.lic you were issued.")
+	default:
+		status, hint := statusPass, ""
+		if time.Until(lic.ExpiresAt) < 30*24*time.Hour {
+			status, hint = statusWarn, "The license expires within 30 days; contact support to renew it."
+		}
+		d.report(name, status, fmt.Sprintf("%s, company %s, valid until %s", licPath, lic.CompanyID, lic.ExpiresAt.Format(time.DateOnly)), hint)
+	}
+}
+
+func (d *doctor) checkConfig(cfg config.AppConfig, err error) {
+	source := "environment and license only"
+	if cfg.ConfigPath != "" {
+		source = cfg.ConfigPath
+	}
+	if err != nil {
+		d.report("Configuration", statusFail, fmt.Sprintf("%s:\n%v", source, err),
+			"Fix the problems listed above; see config.example.json for the expected format.")
+		return
+	}
+	d.report("Configuration", statusPass, fmt.Sprintf("%s, %d job(s)", source, len(cfg.Jobs)), "")
+}
+
+// checkBackups returns the newest backup found across all jobs.
+func (d *doctor) checkBackups(cfg config.AppConfig) string {
+	if len(cfg.Jobs) == 0 {
+		d.report("Backup folder", statusSkip, "no jobs configured", "Set LOCAL_FOLDER_PATH or define jobs in the config file.")
+		return ""
+	}
+	var latest string
+	for _, job := range cfg.Jobs {
+		name := fmt.Sprintf("Backup folder (%s)", job.Name)
+		info, err := os.Stat(job.LocalFolderPath)
+		if err != nil || !info.IsDir() {
+			d.report(name, statusFail, fmt.Sprintf("%s does not exist or is not a directory", job.LocalFolderPath),
+				"Point LOCAL_FOLDER_PATH (or the job's local_folder_path) at Tally's backup folder.")
+			continue
+		}
+		path, size, err := utils.FindZipFileWithPatternAndLatestDate(job.LocalFolderPath)
+		if err != nil || size == 0 {
+			d.report(name, statusFail, fmt.Sprintf("no non-empty .zip backups in %s", job.LocalFolderPath),
+				"Check Tally's backup destination, and that backups are named like Tallybackupason<DDMMYYYY>.zip.")
+			continue
+		}
+		latest = path
+		fileInfo, _ := os.Stat(path)
+		detail := fmt.Sprintf("latest %s (%d MB, modified %s)", filepath.Base(path), size/1024/1024, fileInfo.ModTime().Format(time.DateTime))
+		if err := backup.VerifyZip(path); err != nil {
+			d.report(name, statusWarn, fmt.Sprintf("%s is corrupt: %v", filepath.Base(path), err),
+				"Uploads fall back to the previous intact backup; check that Tally finishes writing its backups.")
+			continue
+		}
+		if time.Since(fileInfo.ModTime()) > staleBackup {
+			d.report(name, statusWarn, detail, "The newest backup is over two days old; check that Tally's scheduled backup still runs.")
+			continue
+		}
+		d.report(name, statusPass, detail, "")
+	}
+	return latest
+}
+
+func (d *doctor) checkAPI(cfg config.AppConfig) (*api.APIClient, *models.Company) {
+	if cfg.APIBaseUrl == "" || cfg.APIKey == "" {
+		d.report("API reachable", statusSkip, "API base URL or key not configured", "")
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.APIBaseUrl)
+	if err != nil || u.Host == "" {
+		d.report("API reachable", statusFail, fmt.Sprintf("invalid API base URL %q", cfg.APIBaseUrl), "Check API_BASE_URL.")
+		return nil, nil
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	if cfg.Network.ProxyURL == "" {
+		conn, err := net.DialTimeout("tcp", host, 10*time.Second)
+		if err != nil {
+			d.report("API reachable", statusFail, err.Error(),
+				"Check the internet connection and firewall, or configure PROXY_URL if a proxy is required.")
+			return nil, nil
+		}
+		conn.Close()
+		d.report("API reachable", statusPass, host, "")
+	} else {
+		d.report("API reachable", statusSkip, "direct connection not tested because a proxy is configured", "")
+	}
+
+	client, err := api.NewAPIClientFromConfig(cfg)
+	if err != nil {
+		d.report("API key", statusFail, err.Error(), "Fix the network settings (proxy, CA bundle, client certificate).")
+		return nil, nil
+	}
+	company, err := client.FindCompanyByAPIKey(cfg.APIKey)
+	if err != nil {
+		d.report("API key", statusFail, err.Error(),
+			"The backend rejected the request; check that the API key in the license is current, or the TLS/proxy settings.")
+		return nil, nil
+	}
+	detail := fmt.Sprintf("accepted for %s", company.CompanyName)
+	if company.TotalUsageQuota != nil && company.UsedQuota != nil {
+		detail += fmt.Sprintf(", %d of %d MB used", *company.UsedQuota/1024/1024, *company.TotalUsageQuota/1024/1024)
+		if *company.UsedQuota >= *company.TotalUsageQuota {
+			d.report("API key", statusWarn, detail, "The quota is used up; run 'sh-backups -D' or ask for a larger quota.")
+			return client, company
+		}
+	}
+	d.report("API key", statusPass, detail, "")
+	return client, company
+}
+
+// checkClock compares the local clock with the x-amz-date of a presigned
+// upload; a large skew makes S3 reject the signed request.
+func (d *doctor) checkClock(client *api.APIClient, company *models.Company, latest string) {
+	const name = "Clock skew"
+	if client == nil || company == nil || latest == "" {
+		d.report(name, statusSkip, "needs a reachable API and a local backup to presign", "")
+		return
+	}
+	presign, err := client.GeneratePresignURL(company.CompanyApiKey, latest)
+	if err != nil || presign == nil {
+		d.report(name, statusFail, fmt.Sprintf("could not get a presigned upload: %v", err), "Check the API key and quota.")
+		return
+	}
+	serverTime, err := time.Parse(amzDateFormat, presign.Fields["x-amz-date"])
+	if err != nil {
+		d.report(name, statusSkip, "server did not return a usable x-amz-date", "")
+		return
+	}
+	skew := time.Since(serverTime).Round(time.Second)
+	if skew.Abs() > maxClockSkew {
+		d.report(name, statusFail, fmt.Sprintf("local clock differs from the server by %s", skew),
+			"Enable automatic time synchronisation (Windows: Settings > Time & language > Sync now).")
+		return
+	}
+	d.report(name, statusPass, skew.String(), "")
+}
+
+func (d *doctor) checkLogs(cfg config.AppConfig) {
+	const name = "Logs directory"
+	dir := cfg.Logging.Dir
+	if dir == "" {
+		dir = logger.DefaultDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		d.report(name, statusFail, err.Error(), "Set logging.dir or LOG_DIR to a writable folder.")
+		return
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		d.report(name, statusFail, err.Error(), "Make the logs directory writable, or set logging.dir or LOG_DIR to a writable folder.")
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.report(name, statusPass, dir+" is writable", "")
+}
@@ -0,0 +1,128 @@
+// Package history keeps the summaries of past runs in a local JSON Lines
+// file, one models.RunSummary per line, and exports them as CSV or JSON.
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// DefaultPath is ~/.sh_backups/history.jsonl.
+func DefaultPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "history.jsonl"
+	}
+	return filepath.Join(homeDir, ".sh_backups", "history.jsonl")
+}
+
+// Append adds summary as the last line of the history file, creating the
+// file and its directory if needed.
+func Append(path string, summary *models.RunSummary) error {
+	line, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Read returns every run in the history file, oldest first. A missing file
+// is an empty history; lines that cannot be parsed, such as one cut short
+// by a crash, are skipped with a warning.
+func Read(path string) ([]models.RunSummary, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []models.RunSummary
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var run models.RunSummary
+		if err := json.Unmarshal([]byte(line), &run); err != nil {
+			logger.Warn("Skipping unreadable history line", "file", path, "line", n, "error", err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+	return runs, scanner.Err()
+}
+
+// Last returns the most recent n runs, oldest first; n <= 0 returns all.
+func Last(runs []models.RunSummary, n int) []models.RunSummary {
+	if n <= 0 || n >= len(runs) {
+		return runs
+	}
+	return runs[len(runs)-n:]
+}
+
+// WriteJSON writes runs as an indented JSON array.
+func WriteJSON(w io.Writer, runs []models.RunSummary) error {
+	if runs == nil {
+		runs = []models.RunSummary{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(runs)
+}
+
+var csvHeader = []string{
+	"run_id", "company_id", "host", "operation", "jobs", "started_at", "finished_at", "duration_ms",
+	"files_considered", "files_uploaded", "files_skipped", "files_deleted", "bytes_uploaded", "bytes_deleted",
+	"errors", "exit_status", "files_mirrored", "bytes_mirrored", "files_verified",
+}
+
+// WriteCSV writes runs with a header row. Jobs and errors are joined with
+// "; ".
+func WriteCSV(w io.Writer, runs []models.RunSummary) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range runs {
+		record := []string{
+			r.RunId, r.CompanyId, r.Host, r.Operation, strings.Join(r.Jobs, "; "), r.StartedAt, r.FinishedAt,
+			strconv.FormatInt(r.DurationMs, 10),
+			strconv.Itoa(r.FilesConsidered), strconv.Itoa(r.FilesUploaded), strconv.Itoa(r.FilesSkipped), strconv.Itoa(r.FilesDeleted),
+			strconv.FormatInt(r.BytesUploaded, 10), strconv.FormatInt(r.BytesDeleted, 10),
+			strings.Join(r.Errors, "; "), strconv.Itoa(r.ExitStatus),
+			strconv.Itoa(r.FilesMirrored), strconv.FormatInt(r.BytesMirrored, 10), strconv.Itoa(r.FilesVerified),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,128 @@
+package history
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+func run(id string, uploaded int) models.RunSummary {
+	return models.RunSummary{
+		RunId:         id,
+		Operation:     "upload",
+		Jobs:          []string{"tally", "gst"},
+		StartedAt:     "2026-03-01T21:30:00+05:30",
+		FinishedAt:    "2026-03-01T21:31:00+05:30",
+		DurationMs:    60000,
+		FilesUploaded: uploaded,
+		BytesUploaded: int64(uploaded) * 100,
+	}
+}
+
+func TestAppendAndRead(t *testing.T) {
+	// The directory is created on first use.
+	path := filepath.Join(t.TempDir(), "state", "history.jsonl")
+	if runs, err := Read(path); err != nil || runs != nil {
+		t.Fatalf("Read of a missing file = %v, %v; want an empty history", runs, err)
+	}
+	want := []models.RunSummary{run("a", 1), run("b", 2), run("c", 3)}
+	want[2].Errors, want[2].ExitStatus = []string{"tally: upload failed"}, 1
+	for i := range want {
+		if err := Append(path, &want[i]); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read = %+v\nwant %+v", got, want)
+	}
+	if last := Last(got, 2); len(last) != 2 || last[0].RunId != "b" || last[1].RunId != "c" {
+		t.Errorf("Last(2) = %+v, want runs b and c", last)
+	}
+	for _, n := range []int{0, -1, 3, 10} {
+		if last := Last(got, n); len(last) != 3 {
+			t.Errorf("Last(%d) returned %d runs, want all 3", n, len(last))
+		}
+	}
+}
+
+// A line cut short by a crash is skipped; the runs around it still read.
+func TestReadSkipsDamagedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := Append(path, &models.RunSummary{RunId: "a", Operation: "upload"}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"run_id\": \"cut\", \"oper\n\n")
+	f.Close()
+	if err := Append(path, &models.RunSummary{RunId: "b", Operation: "delete"}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].RunId != "a" || runs[1].RunId != "b" {
+		t.Errorf("Read = %+v, want runs a and b", runs)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("WriteJSON(nil) = %q, want an empty array", buf.String())
+	}
+	buf.Reset()
+	want := []models.RunSummary{run("a", 1)}
+	if err := WriteJSON(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	var got []models.RunSummary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteJSON round trip = %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	r := run("a", 2)
+	r.Errors = []string{"first", "second"}
+	r.ExitStatus = 1
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, []models.RunSummary{r}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !reflect.DeepEqual(records[0], csvHeader) {
+		t.Fatalf("CSV = %v, want a header and one row", records)
+	}
+	row := map[string]string{}
+	for i, name := range csvHeader {
+		row[name] = records[1][i]
+	}
+	for name, want := range map[string]string{
+		"run_id": "a", "jobs": "tally; gst", "files_uploaded": "2", "bytes_uploaded": "200",
+		"errors": "first; second", "exit_status": "1", "duration_ms": "60000",
+	} {
+		if row[name] != want {
+			t.Errorf("%s = %q, want %q", name, row[name], want)
+		}
+	}
+}
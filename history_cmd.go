@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/history"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// runHistory lists recent runs from the history file or exports them:
+//
+//	sh-backups history -n 20
+//	sh-backups history -n 0 -format csv -out runs.csv
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	overrides := configFlags(fs)
+	limit := fs.Int("n", 20, "number of most recent runs to show (0 for all)")
+	format := fs.String("format", "table", "output format: table, csv or json")
+	out := fs.String("out", "", "file to write to (default: stdout)")
+	_ = fs.Parse(args)
+
+	// The history is local, so a configuration that fails validation (for
+	// example an expired license) must not hide it.
+	cfg, err := config.Load(*overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: configuration has problems, using history file %s\n", historyPath(cfg))
+	}
+	runs, err := history.Read(historyPath(cfg))
+	if err != nil {
+		exitErr("Failed to read run history", err)
+	}
+	runs = history.Last(runs, *limit)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			exitErr("Failed to create output file", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "table":
+		err = writeHistoryTable(w, runs)
+	case "csv":
+		err = history.WriteCSV(w, runs)
+	case "json":
+		err = history.WriteJSON(w, runs)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q (use table, csv or json)\n", *format)
+		os.Exit(2)
+	}
+	if err != nil {
+		exitErr("Failed to write run history", err)
+	}
+}
+
+func writeHistoryTable(w io.Writer, runs []models.RunSummary) error {
+	if len(runs) == 0 {
+		_, err := fmt.Fprintln(w, "No runs recorded yet.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tRUN ID\tOPERATION\tJOBS\tCONSIDERED\tUPLOADED\tSKIPPED\tDELETED\tMIRRORED\tVERIFIED\tBYTES UP\tBYTES DEL\tDURATION\tSTATUS")
+	for _, r := range runs {
+		status := "ok"
+		if r.ExitStatus != 0 {
+			status = fmt.Sprintf("failed (%d errors)", len(r.Errors))
+		}
+		started := r.StartedAt
+		if t, err := time.Parse(time.RFC3339, r.StartedAt); err == nil {
+			started = t.Local().Format(time.DateTime)
+		}
+		runID := r.RunId
+		if len(runID) > 8 {
+			runID = runID[:8]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			started, runID, r.Operation, strings.Join(r.Jobs, ","),
+			r.FilesConsidered, r.FilesUploaded, r.FilesSkipped, r.FilesDeleted, r.FilesMirrored, r.FilesVerified, r.BytesUploaded, r.BytesDeleted,
+			time.Duration(r.DurationMs)*time.Millisecond, status)
+	}
+	return tw.Flush()
+}
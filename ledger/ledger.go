@@ -0,0 +1,132 @@
+// Package ledger keeps a local record of the backups this host holds
+// remotely, by name and size, so the company's used quota can be checked
+// against what was actually uploaded and deleted.
+package ledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// DefaultPath is ~/.sh_backups/ledger.json.
+func DefaultPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "ledger.json"
+	}
+	return filepath.Join(homeDir, ".sh_backups", "ledger.json")
+}
+
+// Ledger maps the file names of remote backups to their stored size; the
+// chunks of chunked backups are recorded as TallyChunks/<sha256>. Every
+// change is written to disk straight away; write failures are logged, since
+// the next reconcile rebuilds the ledger from the remote listing. A nil
+// *Ledger ignores changes.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+	data ledgerFile
+}
+
+type ledgerFile struct {
+	UpdatedAt string           `json:"updated_at,omitempty"`
+	Files     map[string]int64 `json:"files"`
+}
+
+// Load reads the ledger at path. A missing file is an empty ledger.
+func Load(path string) (*Ledger, error) {
+	l := &Ledger{path: path, data: ledgerFile{Files: map[string]int64{}}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &l.data); err != nil {
+		return nil, fmt.Errorf("invalid ledger %s: %w", path, err)
+	}
+	if l.data.Files == nil {
+		l.data.Files = map[string]int64{}
+	}
+	return l, nil
+}
+
+// Set records name as stored with size bytes, replacing any earlier entry.
+func (l *Ledger) Set(name string, size int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data.Files[name] = size
+	l.save()
+}
+
+// Remove forgets names.
+func (l *Ledger) Remove(names ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, name := range names {
+		delete(l.data.Files, name)
+	}
+	l.save()
+}
+
+// Replace makes the ledger hold exactly files.
+func (l *Ledger) Replace(files []models.RemoteFile) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data.Files = make(map[string]int64, len(files))
+	for _, f := range files {
+		l.data.Files[f.FileName] = f.Size
+	}
+	l.save()
+}
+
+// Total is the sum of the recorded sizes.
+func (l *Ledger) Total() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total int64
+	for _, size := range l.data.Files {
+		total += size
+	}
+	return total
+}
+
+// save writes the ledger through a temporary file so a crash cannot leave
+// it half written. l.mu must be held.
+func (l *Ledger) save() {
+	l.data.UpdatedAt = time.Now().Format(time.RFC3339)
+	b, err := json.MarshalIndent(l.data, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(l.path), 0o755)
+	}
+	if err == nil {
+		tmp := l.path + ".tmp"
+		if err = os.WriteFile(tmp, b, 0o644); err == nil {
+			err = os.Rename(tmp, l.path)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to write quota ledger", err, "file", l.path)
+	}
+}
@@ -0,0 +1,136 @@
+package license
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	encPrefix   = "v1:"
+	secretBytes = 32
+	keyInfo     = "sh_backups api key v1"
+)
+
+// machineID is MachineID; tests replace it to stand for another machine.
+var machineID = MachineID
+
+var ErrDecryptAPIKey = errors.New("cannot decrypt the API key; the license was encrypted on another machine or the local secret file is missing")
+
+// SecretPath is the per-user file holding the random half of the key that
+// encrypts the API key. The other half is the machine ID.
+func SecretPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".sh_backups", "license.secret")
+}
+
+// IsEncrypted reports whether value was produced by EncryptAPIKey.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encPrefix)
+}
+
+// EncryptAPIKey seals apiKey with the machine-bound key, creating the local
+// secret file on first use.
+func EncryptAPIKey(apiKey string) (string, error) {
+	aead, err := machineAEAD(true)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(apiKey), []byte(keyInfo))
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptAPIKey(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", fmt.Errorf("unsupported encrypted API key format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil {
+		return "", ErrDecryptAPIKey
+	}
+	aead, err := machineAEAD(false)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrDecryptAPIKey, err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrDecryptAPIKey
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(keyInfo))
+	if err != nil {
+		return "", ErrDecryptAPIKey
+	}
+	return string(plain), nil
+}
+
+func machineAEAD(create bool) (cipher.AEAD, error) {
+	id, err := machineID()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := loadSecret(create)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdf.Key(sha256.New, secret, []byte(id), keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func loadSecret(create bool) ([]byte, error) {
+	path := SecretPath()
+	raw, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+		if err != nil || len(secret) != secretBytes {
+			return nil, fmt.Errorf("secret file %s is malformed", path)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, err
+	}
+
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(secret) + "\n"
+	// O_EXCL so two concurrent first runs cannot overwrite each other's secret.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			return loadSecret(false)
+		}
+		return nil, err
+	}
+	if _, err := f.WriteString(encoded); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return secret, f.Close()
+}
@@ -0,0 +1,149 @@
+package license
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// onMachine points SecretPath at an empty home directory and makes the
+// machine ID id.
+func onMachine(t *testing.T, id string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	switchMachine(t, id)
+}
+
+func switchMachine(t *testing.T, id string) {
+	previous := machineID
+	machineID = func() (string, error) { return id, nil }
+	t.Cleanup(func() { machineID = previous })
+}
+
+func TestEncryptAPIKeyRoundTrip(t *testing.T) {
+	onMachine(t, "machine-a")
+	sealed, err := EncryptAPIKey("secret-api-key")
+	if err != nil {
+		t.Fatalf("EncryptAPIKey: %v", err)
+	}
+	if !IsEncrypted(sealed) || strings.Contains(sealed, "secret-api-key") {
+		t.Errorf("EncryptAPIKey = %q, want an encrypted value", sealed)
+	}
+	again, err := EncryptAPIKey("secret-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("encrypting twice gave the same value; the nonce is not random")
+	}
+	for _, v := range []string{sealed, again} {
+		if got, err := DecryptAPIKey(v); err != nil || got != "secret-api-key" {
+			t.Errorf("DecryptAPIKey = %q, %v; want the API key", got, err)
+		}
+	}
+}
+
+func TestSecretFileIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not have Unix permissions")
+	}
+	onMachine(t, "machine-a")
+	if _, err := EncryptAPIKey("secret-api-key"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(SecretPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("secret file mode = %o, want 600", perm)
+	}
+	dir, err := os.Stat(filepath.Dir(SecretPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := dir.Mode().Perm(); perm != 0o700 {
+		t.Errorf("secret directory mode = %o, want 700", perm)
+	}
+}
+
+func TestDecryptAPIKeyOnAnotherMachine(t *testing.T) {
+	onMachine(t, "machine-a")
+	sealed, err := EncryptAPIKey("secret-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	switchMachine(t, "machine-b")
+	if _, err := DecryptAPIKey(sealed); !errors.Is(err, ErrDecryptAPIKey) {
+		t.Errorf("DecryptAPIKey on another machine = %v, want ErrDecryptAPIKey", err)
+	}
+}
+
+func TestDecryptAPIKeyWithAnotherSecret(t *testing.T) {
+	onMachine(t, "machine-a")
+	sealed, err := EncryptAPIKey("secret-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(SecretPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptAPIKey(sealed); !errors.Is(err, ErrDecryptAPIKey) {
+		t.Errorf("DecryptAPIKey without the secret file = %v, want ErrDecryptAPIKey", err)
+	}
+	if _, err := os.Stat(SecretPath()); !os.IsNotExist(err) {
+		t.Error("DecryptAPIKey created a secret file")
+	}
+
+	// Encrypting again creates a new secret, which cannot open the old value.
+	if _, err := EncryptAPIKey("other-key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptAPIKey(sealed); !errors.Is(err, ErrDecryptAPIKey) {
+		t.Errorf("DecryptAPIKey with a new secret = %v, want ErrDecryptAPIKey", err)
+	}
+}
+
+func TestDecryptAPIKeyRejectsTampering(t *testing.T) {
+	onMachine(t, "machine-a")
+	sealed, err := EncryptAPIKey("secret-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, encPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 1
+	for _, v := range []string{
+		encPrefix + base64.StdEncoding.EncodeToString(raw),
+		encPrefix + "not base64!",
+		encPrefix + base64.StdEncoding.EncodeToString([]byte("short")),
+	} {
+		if _, err := DecryptAPIKey(v); !errors.Is(err, ErrDecryptAPIKey) {
+			t.Errorf("DecryptAPIKey(%q) = %v, want ErrDecryptAPIKey", v, err)
+		}
+	}
+	if _, err := DecryptAPIKey("secret-api-key"); err == nil {
+		t.Error("DecryptAPIKey accepted a plain value")
+	}
+}
+
+func TestMalformedSecretFile(t *testing.T) {
+	onMachine(t, "machine-a")
+	if err := os.MkdirAll(filepath.Dir(SecretPath()), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(SecretPath(), []byte("too short\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncryptAPIKey("secret-api-key"); err == nil || !strings.Contains(err.Error(), "malformed") {
+		t.Errorf("EncryptAPIKey = %v, want the malformed secret reported", err)
+	}
+}
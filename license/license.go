@@ -0,0 +1,213 @@
+// Package license reads and verifies the signed apikey.lic files issued to
+// each company.
+//
+// A license file is a small JSON document. Its claims are stored as a
+// base64-encoded JSON blob and signed with ed25519, so any edit to them is
+// detected. The API key itself is kept outside the signed blob (it is bound
+// to the claims by hash) so it can later be re-encoded without re-issuing
+// the license.
+package license
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the license file version written by Sign.
+const FormatVersion = 1
+
+var (
+	ErrNotSigned          = errors.New("license is not a signed license file")
+	ErrUnsupportedVersion = errors.New("unsupported license version")
+	ErrInvalidSignature   = errors.New("license signature is invalid; the file may have been modified")
+	ErrExpired            = errors.New("license has expired")
+	ErrNotYetValid        = errors.New("license is not valid yet")
+	ErrAPIKeyMismatch     = errors.New("API key does not match the license")
+)
+
+// Claims are the signed contents of a license.
+type Claims struct {
+	CompanyID  string `json:"company_id"`
+	APIBaseURL string `json:"api_base_url"`
+	// APIKeySHA256 binds the unsigned API key to these claims.
+	APIKeySHA256 string    `json:"api_key_sha256,omitempty"`
+	IssuedAt     time.Time `json:"issued_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	Features     []string  `json:"features,omitempty"`
+}
+
+// File is the on-disk representation of a license.
+type File struct {
+	Version   int    `json:"version"`
+	Claims    string `json:"claims"`
+	Signature string `json:"signature"`
+	APIKey    string `json:"api_key,omitempty"`
+	// APIKeyEnc replaces APIKey once the key is encrypted at rest.
+	APIKeyEnc string `json:"api_key_enc,omitempty"`
+}
+
+// License is a verified license.
+type License struct {
+	Claims
+	APIKey string
+}
+
+// HasFeature reports whether the license allows the named feature. A nil
+// license, when running without one, allows none.
+func (l *License) HasFeature(name string) bool {
+	return l != nil && slices.Contains(l.Features, name)
+}
+
+//go:embed public.key
+var embeddedPublicKey string
+
+// publicKeyOverride replaces the embedded verification key at build time:
+//
+//	go build -ldflags "-X shreshtasmg.in/sh_backups/license.publicKeyOverride=<base64 key>"
+var publicKeyOverride string
+
+// PublicKey returns the ed25519 key licenses are verified against.
+func PublicKey() (ed25519.PublicKey, error) {
+	encoded := embeddedPublicKey
+	if publicKeyOverride != "" {
+		encoded = publicKeyOverride
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("license verification key is malformed")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// IsSigned reports whether data looks like a signed license file rather than
+// a legacy dotenv-style apikey.lic.
+func IsSigned(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return false
+	}
+	var probe struct {
+		Version int `json:"version"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Version > 0
+}
+
+func Parse(data []byte) (*File, error) {
+	if !IsSigned(data) {
+		return nil, ErrNotSigned
+	}
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("malformed license file: %w", err)
+	}
+	if f.Version != FormatVersion {
+		return nil, fmt.Errorf("%w %d (this build supports version %d)", ErrUnsupportedVersion, f.Version, FormatVersion)
+	}
+	return &f, nil
+}
+
+// Verify checks the signature and validity window of a license file. The
+// returned License carries whatever API key is stored in the file, which
+// may be empty, decrypted if it was encrypted at rest.
+func Verify(data []byte, pub ed25519.PublicKey, now time.Time) (*License, error) {
+	f, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	claimsJSON, err := base64.StdEncoding.DecodeString(f.Claims)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(f.Signature)
+	if err != nil || !ed25519.Verify(pub, claimsJSON, sig) {
+		return nil, ErrInvalidSignature
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("malformed license claims: %w", err)
+	}
+	if !claims.IssuedAt.IsZero() && now.Before(claims.IssuedAt) {
+		return nil, fmt.Errorf("%w: issued for %s", ErrNotYetValid, claims.IssuedAt.Format(time.DateOnly))
+	}
+	if claims.ExpiresAt.IsZero() || !now.Before(claims.ExpiresAt) {
+		return nil, fmt.Errorf("%w on %s", ErrExpired, claims.ExpiresAt.Format(time.DateOnly))
+	}
+	apiKey := f.APIKey
+	if f.APIKeyEnc != "" {
+		if apiKey, err = DecryptAPIKey(f.APIKeyEnc); err != nil {
+			return nil, err
+		}
+	}
+	return &License{Claims: claims, APIKey: apiKey}, nil
+}
+
+// EncryptFile rewrites a signed license so its API key is encrypted at rest.
+// It reports false when there was no plaintext key to encrypt.
+func EncryptFile(data []byte) ([]byte, bool, error) {
+	f, err := Parse(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if f.APIKey == "" {
+		return data, false, nil
+	}
+	if f.APIKeyEnc, err = EncryptAPIKey(f.APIKey); err != nil {
+		return nil, false, err
+	}
+	f.APIKey = ""
+	out, err := Marshal(f)
+	return out, true, err
+}
+
+// CheckAPIKey verifies apiKey against the hash bound into the claims.
+func (l *License) CheckAPIKey(apiKey string) error {
+	if l.APIKeySHA256 == "" {
+		return nil
+	}
+	if HashAPIKey(apiKey) != l.APIKeySHA256 {
+		return ErrAPIKeyMismatch
+	}
+	return nil
+}
+
+// Sign issues a license file for claims, storing apiKey alongside them.
+func Sign(priv ed25519.PrivateKey, claims Claims, apiKey string) ([]byte, error) {
+	if apiKey != "" {
+		claims.APIKeySHA256 = HashAPIKey(apiKey)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	f := File{
+		Version:   FormatVersion,
+		Claims:    base64.StdEncoding.EncodeToString(claimsJSON),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(priv, claimsJSON)),
+		APIKey:    apiKey,
+	}
+	return Marshal(&f)
+}
+
+func Marshal(f *File) ([]byte, error) {
+	out, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
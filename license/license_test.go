@@ -0,0 +1,141 @@
+package license
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	issued  = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func issue(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey, []byte) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := Sign(priv, Claims{
+		CompanyID:  "company-1",
+		APIBaseURL: "https://api.example.com",
+		IssuedAt:   issued,
+		ExpiresAt:  expires,
+		Features:   []string{"mirror"},
+	}, "secret-api-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv, data
+}
+
+// tamper rewrites the claims of a signed license file with edit, keeping
+// the original signature.
+func tamper(t *testing.T, data []byte, edit func(*Claims)) []byte {
+	t.Helper()
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(f.Claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		t.Fatal(err)
+	}
+	edit(&claims)
+	raw, err = json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Claims = base64.StdEncoding.EncodeToString(raw)
+	out, err := Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestVerify(t *testing.T) {
+	pub, _, data := issue(t)
+	lic, err := Verify(data, pub, issued.AddDate(0, 6, 0))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if lic.CompanyID != "company-1" || lic.APIKey != "secret-api-key" {
+		t.Errorf("Verify = %+v, want company-1 with its API key", lic)
+	}
+	if !lic.HasFeature("mirror") || lic.HasFeature("verify") {
+		t.Errorf("features = %v, want only mirror", lic.Features)
+	}
+	if err := lic.CheckAPIKey("secret-api-key"); err != nil {
+		t.Errorf("CheckAPIKey(issued key): %v", err)
+	}
+	if err := lic.CheckAPIKey("other-key"); !errors.Is(err, ErrAPIKeyMismatch) {
+		t.Errorf("CheckAPIKey(other key) = %v, want ErrAPIKeyMismatch", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	pub, _, data := issue(t)
+	otherPub, _, _ := issue(t)
+	during := issued.AddDate(0, 6, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+		pub  ed25519.PublicKey
+		now  time.Time
+		want error
+	}{
+		{"extended expiry", tamper(t, data, func(c *Claims) { c.ExpiresAt = expires.AddDate(10, 0, 0) }), pub, during, ErrInvalidSignature},
+		{"added feature", tamper(t, data, func(c *Claims) { c.Features = append(c.Features, "verify") }), pub, during, ErrInvalidSignature},
+		{"other company", tamper(t, data, func(c *Claims) { c.CompanyID = "company-2" }), pub, during, ErrInvalidSignature},
+		{"other signing key", data, otherPub, during, ErrInvalidSignature},
+		{"expired", data, pub, expires, ErrExpired},
+		{"long expired", data, pub, expires.AddDate(1, 0, 0), ErrExpired},
+		{"not yet valid", data, pub, issued.Add(-time.Hour), ErrNotYetValid},
+		{"legacy dotenv", []byte("API_KEY=secret-api-key\n"), pub, during, ErrNotSigned},
+		{"future version", []byte(strings.Replace(string(data), `"version": 1`, `"version": 2`, 1)), pub, during, ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lic, err := Verify(tt.data, tt.pub, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %+v, %v; want %v", lic, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasFeatureWithoutLicense(t *testing.T) {
+	var lic *License
+	if lic.HasFeature("mirror") {
+		t.Error("a missing license allows the mirror feature")
+	}
+}
+
+func TestIsSigned(t *testing.T) {
+	_, _, data := issue(t)
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{string(data), true},
+		{"API_KEY=abc\nAPI_BASE_URL=https://api.example.com\n", false},
+		{"", false},
+		{`{"claims": "x"}`, false},
+	}
+	for _, tt := range tests {
+		if got := IsSigned([]byte(tt.data)); got != tt.want {
+			t.Errorf("IsSigned(%.20q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
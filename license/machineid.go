@@ -0,0 +1,51 @@
+package license
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// MachineID returns a stable identifier for this machine, used to bind the
+// encrypted API key to the host it was encrypted on.
+func MachineID() (string, error) {
+	var id string
+	switch runtime.GOOS {
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && fields[0] == "MachineGuid" {
+				id = fields[2]
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.Contains(line, `"IOPlatformUUID"`) {
+				if _, value, ok := strings.Cut(line, "="); ok {
+					id = strings.Trim(strings.TrimSpace(value), `"`)
+				}
+			}
+		}
+	default:
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if raw, err := os.ReadFile(path); err == nil {
+				id = strings.TrimSpace(string(raw))
+				break
+			}
+		}
+	}
+	if id == "" {
+		return "", errors.New("could not determine the machine ID")
+	}
+	return id, nil
+}
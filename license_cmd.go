@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/license"
+	"shreshtasmg.in/sh_backups/logger"
+)
+
+// runLicense implements the vendor-side license tooling:
+//
+//	sh-backups license keygen -out signing.key
+//	sh-backups license issue -key signing.key -company-id ... -api-key ... -out apikey.lic
+//
+// and the customer-side migration that encrypts the API key at rest:
+//
+//	sh-backups license encrypt
+func runLicense(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sh-backups license <keygen|issue|encrypt> [flags]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "keygen":
+		licenseKeygen(args[1:])
+	case "issue":
+		licenseIssue(args[1:])
+	case "encrypt":
+		licenseEncrypt(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown license command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func licenseKeygen(args []string) {
+	fs := flag.NewFlagSet("license keygen", flag.ExitOnError)
+	out := fs.String("out", "license_signing.key", "file to write the private signing key to")
+	_ = fs.Parse(args)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		exitErr("Failed to generate key", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(priv)
+	if err := os.WriteFile(*out, []byte(encoded+"\n"), 0o600); err != nil {
+		exitErr("Failed to write signing key", err)
+	}
+	fmt.Printf("Private signing key written to %s (keep it secret)\n", *out)
+	fmt.Printf("Public key: %s\n", base64.StdEncoding.EncodeToString(pub))
+}
+
+func licenseIssue(args []string) {
+	fs := flag.NewFlagSet("license issue", flag.ExitOnError)
+	keyPath := fs.String("key", "license_signing.key", "private signing key from 'license keygen'")
+	companyID := fs.String("company-id", "", "company ID the license is issued to")
+	apiKey := fs.String("api-key", "", "company API key")
+	baseURL := fs.String("api-base-url", "", "backend API base URL")
+	expires := fs.String("expires", "", "expiry date (YYYY-MM-DD)")
+	features := fs.String("features", "", "comma-separated list of allowed features")
+	out := fs.String("out", "apikey.lic", "license file to write")
+	_ = fs.Parse(args)
+
+	if *companyID == "" || *apiKey == "" || *baseURL == "" || *expires == "" {
+		fmt.Fprintln(os.Stderr, "-company-id, -api-key, -api-base-url and -expires are required")
+		os.Exit(2)
+	}
+	expiresAt, err := time.Parse(time.DateOnly, *expires)
+	if err != nil {
+		exitErr("Invalid -expires date", err)
+	}
+	raw, err := os.ReadFile(*keyPath)
+	if err != nil {
+		exitErr("Failed to read signing key", err)
+	}
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		exitErr("Signing key is malformed", err)
+	}
+
+	claims := license.Claims{
+		CompanyID:  *companyID,
+		APIBaseURL: *baseURL,
+		IssuedAt:   time.Now().UTC().Truncate(time.Second),
+		ExpiresAt:  expiresAt.UTC(),
+	}
+	for _, f := range strings.Split(*features, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			claims.Features = append(claims.Features, f)
+		}
+	}
+	data, err := license.Sign(ed25519.PrivateKey(priv), claims, *apiKey)
+	if err != nil {
+		exitErr("Failed to sign license", err)
+	}
+	if err := os.WriteFile(*out, data, 0o600); err != nil {
+		exitErr("Failed to write license", err)
+	}
+	fmt.Printf("License for %s written to %s, valid until %s\n", *companyID, *out, *expires)
+}
+
+func exitErr(msg string, err error) {
+	if err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, err)
+	}
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(1)
+}
+
+// licenseEncrypt converts a license with a plaintext API key, signed or
+// legacy dotenv, into one holding the key encrypted for this machine.
+func licenseEncrypt(args []string) {
+	fs := flag.NewFlagSet("license encrypt", flag.ExitOnError)
+	path := fs.String("file", "", "license file to convert (default: the apikey.lic in use)")
+	_ = fs.Parse(args)
+	if *path == "" {
+		*path = config.LicensePath()
+	}
+	if err := logger.Init(logger.Options{}); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open log files: %v\n", err)
+	}
+
+	data, err := os.ReadFile(*path)
+	if err != nil {
+		exitErr("Failed to read license", err)
+	}
+
+	var out []byte
+	var changed bool
+	if license.IsSigned(data) {
+		out, changed, err = license.EncryptFile(data)
+	} else {
+		out, changed, err = encryptLegacyLicense(data)
+	}
+	if err != nil {
+		exitErr("Failed to encrypt license", err)
+	}
+	if !changed {
+		fmt.Printf("%s has no plaintext API key; nothing to do\n", *path)
+		return
+	}
+	if err := writeFileAtomic(*path, out, 0o600); err != nil {
+		exitErr("Failed to write license", err)
+	}
+	logger.Info("Encrypted API key", "license", *path)
+	fmt.Printf("API key in %s is now encrypted for this machine (secret: %s)\n", *path, license.SecretPath())
+}
+
+func encryptLegacyLicense(data []byte) ([]byte, bool, error) {
+	env, err := godotenv.UnmarshalBytes(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if env["API_KEY"] == "" {
+		return data, false, nil
+	}
+	if env["API_KEY_ENC"], err = license.EncryptAPIKey(env["API_KEY"]); err != nil {
+		return nil, false, err
+	}
+	delete(env, "API_KEY")
+	out, err := godotenv.Marshal(env)
+	return []byte(out + "\n"), true, err
+}
+
+// writeFileAtomic replaces path via a temp file in the same directory so a
+// crash never leaves a half-written file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,50 +1,347 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"shreshtasmg.in/sh_backups/runid"
 )
 
+// Options controls where log files go, how records are formatted and how
+// the files are rotated.
+type Options struct {
+	// Dir holds activity.log and error.log; empty means DefaultDir().
+	Dir string
+	// Level is "debug", "info", "warn" or "error".
+	Level string
+	// Format of the log files: "text" or "json".
+	Format string
+	// Console also writes records to stdout (warnings and errors to stderr).
+	Console  bool
+	Rotation Rotation
+}
+
 var (
-	activityLogger *log.Logger
-	errorLogger    *log.Logger
+	mu           sync.Mutex
+	logDir       string
+	activityFile *RotatingFile
+	errorFile    *RotatingFile
+	level        = new(slog.LevelVar)
+	// Until Init is called, warnings and errors go to stderr only.
+	root = slog.New(&redactHandler{next: &minLevelHandler{min: slog.LevelWarn, next: NewConsoleHandler(os.Stderr, level)}})
 )
 
-func init() {
-	projectRoot, err := os.Getwd()
+// DefaultDir is ~/.sh_backups/logs, which does not depend on the working
+// directory the tool is started from.
+func DefaultDir() string {
+	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatalf("Failed to get current working directory: %v", err)
+		return "logs"
 	}
-	logDir := filepath.Join(projectRoot, "logs")
-	_ = os.MkdirAll(logDir, os.ModePerm)
+	return filepath.Join(homeDir, ".sh_backups", "logs")
+}
 
-	activityFile, err := os.OpenFile(filepath.Join(logDir, "activity.log"),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// Init opens (or reopens) the log files and configures the handlers.
+// activity.log receives every record at or above the configured level,
+// error.log only warnings and errors. Every record passes through Redact
+// first.
+func Init(opts Options) error {
+	dir := opts.Dir
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	activity, err := OpenRotatingFile(filepath.Join(dir, "activity.log"), opts.Rotation)
 	if err != nil {
-		log.Fatalf("Failed to open activity log: %v", err)
+		return fmt.Errorf("failed to open activity log: %w", err)
 	}
-
-	errorFile, err := os.OpenFile(filepath.Join(logDir, "error.log"),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	errs, err := OpenRotatingFile(filepath.Join(dir, "error.log"), opts.Rotation)
 	if err != nil {
-		log.Fatalf("Failed to open error log: %v", err)
+		activity.Close()
+		return fmt.Errorf("failed to open error log: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if activityFile != nil {
+		activityFile.Close()
+		errorFile.Close()
+	}
+	logDir, activityFile, errorFile = dir, activity, errs
+
+	level.Set(ParseLevel(opts.Level))
+	fileHandler := func(w io.Writer) slog.Handler {
+		ho := &slog.HandlerOptions{AddSource: true, Level: level, ReplaceAttr: replaceAttr}
+		if opts.Format == "json" {
+			return slog.NewJSONHandler(w, ho)
+		}
+		return slog.NewTextHandler(w, ho)
+	}
+
+	handlers := []slog.Handler{
+		fileHandler(activityFile),
+		&minLevelHandler{min: slog.LevelWarn, next: fileHandler(errorFile)},
+	}
+	if opts.Console {
+		handlers = append(handlers,
+			&levelRangeHandler{max: slog.LevelWarn, next: NewConsoleHandler(os.Stdout, level)},
+			&minLevelHandler{min: slog.LevelWarn, next: NewConsoleHandler(os.Stderr, level)},
+		)
+	}
+	root = slog.New(&redactHandler{next: fanout(handlers)})
+	return nil
+}
+
+// Close flushes and closes the log files.
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if activityFile != nil {
+		activityFile.Close()
+		errorFile.Close()
+		activityFile, errorFile = nil, nil
+	}
+	root = slog.New(&redactHandler{next: &minLevelHandler{min: slog.LevelWarn, next: NewConsoleHandler(os.Stderr, level)}})
+}
+
+// replaceAttr shortens sources to file:line, like log.Lshortfile, and
+// writes durations as strings in every format.
+func replaceAttr(_ []string, a slog.Attr) slog.Attr {
+	switch {
+	case a.Key == slog.SourceKey:
+		if src, ok := a.Value.Any().(*slog.Source); ok {
+			return slog.String(slog.SourceKey, fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+		}
+	case a.Value.Kind() == slog.KindDuration:
+		return slog.String(a.Key, a.Value.Duration().String())
+	}
+	return a
+}
+
+// ParseLevel maps a level name to a slog.Level, defaulting to info.
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
 	}
+	return slog.LevelInfo
+}
 
-	activityLogger = log.New(activityFile, "[ACTIVITY] ", log.Ldate|log.Ltime|log.Lshortfile)
-	errorLogger = log.New(errorFile, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
+// Logger returns the underlying slog logger, e.g. for slog.SetDefault.
+func Logger() *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	return root
 }
 
-// Info logs to activity.log and stdout
-func Info(msg string) {
-	activityLogger.Println("[INFO] " + msg)
+// Dir returns the directory the log files are written to, or "" before
+// Init.
+func Dir() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return logDir
 }
 
-// Error logs to error.log and stdout
-func Error(msg string, err error) {
-	errorLogger.Printf("[ERROR] %s: %v\n", msg, err)
+// Debug logs a debug record with optional key-value fields.
+func Debug(msg string, args ...any) {
+	write(slog.LevelDebug, msg, args...)
+}
+
+// Info logs to activity.log with optional key-value fields.
+func Info(msg string, args ...any) {
+	write(slog.LevelInfo, msg, args...)
+}
+
+// Warn logs to both activity.log and error.log.
+func Warn(msg string, args ...any) {
+	write(slog.LevelWarn, msg, args...)
+}
+
+// Error logs err to both activity.log and error.log.
+func Error(msg string, err error, args ...any) {
+	if err != nil {
+		args = append([]any{"error", err}, args...)
+	}
+	write(slog.LevelError, msg, args...)
 }
 
+// ErrorFn logs a preformatted error message.
 func ErrorFn(msg string) {
-	errorLogger.Printf("[ERROR] %s\n", msg)
+	write(slog.LevelError, msg)
+}
+
+// write records the caller of the exported function as the source and
+// tags the record with the current run and operation IDs.
+func write(lvl slog.Level, msg string, args ...any) {
+	l := Logger()
+	if !l.Enabled(context.Background(), lvl) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), lvl, msg, pcs[0])
+	if ids := runid.Current(); ids.Run != "" {
+		r.AddAttrs(slog.String("run_id", ids.Run))
+		if ids.Op != "" {
+			r.AddAttrs(slog.String("op_id", ids.Op))
+		}
+	}
+	r.Add(args...)
+	_ = l.Handler().Handle(context.Background(), r)
+}
+
+// fanout sends each record to every handler that accepts its level.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, lvl slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, lvl) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range f {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	out := make(fanout, len(f))
+	for i, h := range f {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// minLevelHandler drops records below min.
+type minLevelHandler struct {
+	min  slog.Level
+	next slog.Handler
+}
+
+func (h *minLevelHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl >= h.min && h.next.Enabled(ctx, lvl)
+}
+
+func (h *minLevelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *minLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &minLevelHandler{min: h.min, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *minLevelHandler) WithGroup(name string) slog.Handler {
+	return &minLevelHandler{min: h.min, next: h.next.WithGroup(name)}
+}
+
+// levelRangeHandler drops records at or above max.
+type levelRangeHandler struct {
+	max  slog.Level
+	next slog.Handler
+}
+
+func (h *levelRangeHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return lvl < h.max && h.next.Enabled(ctx, lvl)
+}
+
+func (h *levelRangeHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelRangeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelRangeHandler{max: h.max, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *levelRangeHandler) WithGroup(name string) slog.Handler {
+	return &levelRangeHandler{max: h.max, next: h.next.WithGroup(name)}
+}
+
+// ConsoleHandler writes compact, human-readable lines:
+//
+//	18:20:07 INFO  Uploaded file to S3 job=tally file=Tallybackupason01102026.zip
+type ConsoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func NewConsoleHandler(w io.Writer, level slog.Leveler) *ConsoleHandler {
+	return &ConsoleHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *ConsoleHandler) Enabled(_ context.Context, lvl slog.Level) bool {
+	return lvl >= h.level.Level()
+}
+
+func (h *ConsoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %-5s %s", r.Time.Format(time.TimeOnly), r.Level.String(), r.Message)
+	appendAttr := func(a slog.Attr) bool {
+		if a.Equal(slog.Attr{}) {
+			return true
+		}
+		value := a.Value.Resolve().String()
+		if strings.ContainsAny(value, " \t\"") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s%s=%s", h.prefix, a.Key, value)
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &next
+}
+
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.prefix = h.prefix + name + "."
+	return &next
 }
@@ -0,0 +1,39 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/runid"
+)
+
+// Records are tagged with the run and operation in progress.
+func TestRecordsCarryRunIDs(t *testing.T) {
+	dir := t.TempDir()
+	if err := Init(Options{Dir: dir, Level: "info", Format: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	run := runid.StartRun()
+	t.Cleanup(runid.EndRun)
+	Info("run started")
+	op := runid.StartOperation()
+	Info("operation started")
+	Close()
+
+	b, err := os.ReadFile(filepath.Join(dir, "activity.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("activity.log has %d records, want 2:\n%s", len(lines), b)
+	}
+	if !strings.Contains(lines[0], `"run_id":"`+run+`"`) || strings.Contains(lines[0], "op_id") {
+		t.Errorf("run-level record = %s, want only the run ID", lines[0])
+	}
+	if !strings.Contains(lines[1], `"run_id":"`+run+`"`) || !strings.Contains(lines[1], `"op_id":"`+op+`"`) {
+		t.Errorf("operation record = %s, want the run and operation IDs", lines[1])
+	}
+}
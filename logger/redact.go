@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+
+	// Patterns for credentials that may appear in messages, errors, headers,
+	// URLs, JSON bodies and multipart form fields.
+	redactPatterns = []struct {
+		re   *regexp.Regexp
+		repl string
+	}{
+		// Header lines: "X-Company-Api-Key: abc", "Authorization: Bearer abc".
+		{regexp.MustCompile(`(?i)((?:x-company-api-key|authorization|proxy-authorization|cookie|set-cookie)\s*[:=]\s*)[^\r\n,;]+`), "${1}" + redacted},
+		// JSON fields: "policy": "...", "company_api_key": "...".
+		{regexp.MustCompile(`(?i)("(?:api_key|api_key_enc|company_api_key|policy|x-amz-signature|x-amz-credential|x-amz-security-token|signature|password|proxy_password|secret)"\s*:\s*)"[^"]*"`), `${1}"` + redacted + `"`},
+		// Multipart form fields of a presigned POST.
+		{regexp.MustCompile(`(?i)(name="(?:policy|x-amz-signature|x-amz-credential|x-amz-security-token)"\r?\n(?:[^\r\n]+\r?\n)*\r?\n)[^\r\n]*`), "${1}" + redacted},
+		// Query parameters of presigned URLs.
+		{regexp.MustCompile(`(?i)((?:x-amz-signature|x-amz-credential|x-amz-security-token|signature|api_key)=)[^&\s"]+`), "${1}" + redacted},
+		// Passwords embedded in URLs, e.g. proxy credentials. The password
+		// runs to the last @ of the host part, as it may contain @ itself.
+		{regexp.MustCompile(`(://[^:/@\s]+:)[^/\s]*@`), "${1}" + redacted + "@"},
+	}
+)
+
+// AddSecret registers literal values, such as the API key, that must never
+// appear in a log record. Values shorter than four characters are ignored,
+// since masking them would garble unrelated text.
+func AddSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		if len(v) >= 4 {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Redact masks registered secrets and well-known credential patterns in s.
+func Redact(s string) string {
+	secretsMu.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	for _, p := range redactPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// redactHandler scrubs the message and every attribute before passing the
+// record on, so no handler ever sees a secret.
+type redactHandler struct {
+	next slog.Handler
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, lvl slog.Level) bool {
+	return h.next.Enabled(ctx, lvl)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, clean)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(clean)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		clean := make([]any, len(group))
+		for i, g := range group {
+			clean[i] = redactAttr(g)
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, Redact(err.Error()))
+		}
+		return slog.String(a.Key, Redact(v.String()))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
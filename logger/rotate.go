@@ -0,0 +1,204 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// Rotation controls when log files are rotated and how many are kept.
+type Rotation struct {
+	// MaxSizeMB rotates a file before it grows past this size; 0 disables.
+	MaxSizeMB int
+	// RotateEvery starts a new file when the current one was last written
+	// in an earlier period (e.g. 24h rotates daily at local midnight); 0
+	// disables.
+	RotateEvery time.Duration
+	// MaxBackups is the number of rotated files kept per log; 0 keeps all.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is an io.Writer that appends to a log file and rotates it by
+// size and age. Rotated files are named like activity-20260118T093000.000.log.
+type RotatingFile struct {
+	mu        sync.Mutex
+	path      string
+	opts      Rotation
+	file      *os.File
+	size      int64
+	lastWrite time.Time
+}
+
+func OpenRotatingFile(path string, opts Rotation) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, opts: opts}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	if info.Size() > 0 {
+		r.lastWrite = info.ModTime()
+	} else {
+		r.lastWrite = time.Time{}
+	}
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.needsRotation(now, int64(len(p))) {
+		if err := r.rotate(now); err != nil {
+			// Keep logging to the current file rather than losing records.
+			fmt.Fprintf(os.Stderr, "log rotation failed for %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	r.lastWrite = now
+	return n, err
+}
+
+func (r *RotatingFile) needsRotation(now time.Time, incoming int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.opts.MaxSizeMB > 0 && r.size+incoming > int64(r.opts.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if r.opts.RotateEvery > 0 && !r.lastWrite.IsZero() &&
+		!periodStart(r.lastWrite, r.opts.RotateEvery).Equal(periodStart(now, r.opts.RotateEvery)) {
+		return true
+	}
+	return false
+}
+
+// periodStart returns the start of the rotation period holding t. Periods
+// are aligned to t's local wall clock: time.Truncate alone aligns them to
+// UTC, which would start daily files at 05:30 in India.
+func periodStart(t time.Time, every time.Duration) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(every).Add(-shift)
+}
+
+func (r *RotatingFile) rotate(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(r.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), now.Format(backupTimeFormat), ext)
+	if err := os.Rename(r.path, backup); err != nil {
+		// Reopen so writes keep working even though rotation failed.
+		_ = r.open()
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	if r.opts.Compress {
+		if err := gzipFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compress %s: %v\n", backup, err)
+		}
+	}
+	return r.prune()
+}
+
+// prune removes the oldest rotated files beyond MaxBackups.
+func (r *RotatingFile) prune() error {
+	if r.opts.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > r.opts.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the rotated files of this log, oldest first.
+func (r *RotatingFile) backups() ([]string, error) {
+	ext := filepath.Ext(r.path)
+	prefix := filepath.Base(strings.TrimSuffix(r.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			out = append(out, filepath.Join(filepath.Dir(r.path), name))
+		}
+	}
+	// The timestamp format sorts lexically in time order.
+	sort.Strings(out)
+	return out, nil
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ist = time.FixedZone("IST", 5*3600+1800)
+
+func TestPeriodStartIsLocalMidnight(t *testing.T) {
+	tests := []struct {
+		t     time.Time
+		every time.Duration
+		want  time.Time
+	}{
+		{time.Date(2026, 1, 18, 23, 30, 0, 0, ist), 24 * time.Hour, time.Date(2026, 1, 18, 0, 0, 0, 0, ist)},
+		{time.Date(2026, 1, 19, 3, 0, 0, 0, ist), 24 * time.Hour, time.Date(2026, 1, 19, 0, 0, 0, 0, ist)},
+		{time.Date(2026, 1, 19, 3, 0, 0, 0, time.UTC), 24 * time.Hour, time.Date(2026, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 1, 19, 14, 45, 0, 0, ist), 6 * time.Hour, time.Date(2026, 1, 19, 12, 0, 0, 0, ist)},
+		{time.Date(2026, 1, 19, 14, 45, 0, 0, ist), time.Hour, time.Date(2026, 1, 19, 14, 0, 0, 0, ist)},
+	}
+	for _, tt := range tests {
+		if got := periodStart(tt.t, tt.every); !got.Equal(tt.want) {
+			t.Errorf("periodStart(%s, %s) = %s, want %s", tt.t, tt.every, got.In(tt.t.Location()), tt.want)
+		}
+	}
+}
+
+func TestNeedsRotationByPeriod(t *testing.T) {
+	tests := []struct {
+		name           string
+		lastWrite, now time.Time
+		rotate         bool
+	}{
+		// Both are the same UTC day, so truncating in UTC misses these.
+		{"across local midnight", time.Date(2026, 1, 18, 23, 50, 0, 0, ist), time.Date(2026, 1, 19, 0, 10, 0, 0, ist), true},
+		{"across UTC midnight only", time.Date(2026, 1, 19, 5, 0, 0, 0, ist), time.Date(2026, 1, 19, 6, 0, 0, 0, ist), false},
+		{"same local day", time.Date(2026, 1, 19, 0, 10, 0, 0, ist), time.Date(2026, 1, 19, 23, 50, 0, 0, ist), false},
+		{"days later", time.Date(2026, 1, 15, 12, 0, 0, 0, ist), time.Date(2026, 1, 19, 12, 0, 0, 0, ist), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RotatingFile{opts: Rotation{RotateEvery: 24 * time.Hour}, size: 1, lastWrite: tt.lastWrite}
+			if got := r.needsRotation(tt.now, 1); got != tt.rotate {
+				t.Errorf("needsRotation = %v, want %v", got, tt.rotate)
+			}
+		})
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "activity.log")
+	r, err := OpenRotatingFile(path, Rotation{MaxSizeMB: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	record := bytes.Repeat([]byte("x"), 600<<10)
+	for i := 0; i < 5; i++ {
+		if _, err := r.Write(record); err != nil {
+			t.Fatal(err)
+		}
+		// Rotated files are named by the time to the millisecond.
+		time.Sleep(2 * time.Millisecond)
+	}
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("kept %d rotated files, want MaxBackups 2: %v", len(backups), backups)
+	}
+	for _, b := range backups {
+		if info, err := os.Stat(b); err != nil || info.Size() != int64(len(record)) {
+			t.Errorf("rotated file %s should hold one record", b)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != int64(len(record)) {
+		t.Errorf("current file should hold the last record")
+	}
+}
+
+func TestRotateByPeriodCompresses(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "activity.log")
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(path, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	// The last write of an existing file is its modification time.
+	r, err := OpenRotatingFile(path, Rotation{RotateEvery: 24 * time.Hour, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Write([]byte("today\n")); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || !strings.HasSuffix(backups[0], ".log.gz") {
+		t.Fatalf("rotated files = %v, want one compressed file", backups)
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "today\n" {
+		t.Errorf("current file = %q, want only today's record", b)
+	}
+}
+
+func TestReopenKeepsAppending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "activity.log")
+	for _, line := range []string{"first\n", "second\n"} {
+		r, err := OpenRotatingFile(path, Rotation{MaxSizeMB: 1, RotateEvery: 24 * time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "first\nsecond\n" {
+		t.Errorf("log = %q, want both records", b)
+	}
+}
@@ -1,166 +1,445 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/backup"
 	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/history"
+	"shreshtasmg.in/sh_backups/ledger"
 	"shreshtasmg.in/sh_backups/logger"
 	"shreshtasmg.in/sh_backups/models"
-	"shreshtasmg.in/sh_backups/utils"
-)
-
-const (
-	locTag = "TallyBackups"
+	"shreshtasmg.in/sh_backups/runid"
+	"shreshtasmg.in/sh_backups/storage"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "mock-server":
+			runMockServer(os.Args[2:])
+			return
+		case "license":
+			runLicense(os.Args[2:])
+			return
+		case "daemon":
+			runDaemon(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "list":
+			runList(os.Args[2:])
+			return
+		case "protect":
+			runProtect(os.Args[2:], true)
+			return
+		case "unprotect":
+			runProtect(os.Args[2:], false)
+			return
+		case "trash":
+			runTrash(os.Args[2:])
+			return
+		case "delete":
+			runDeleteSelected(os.Args[2:])
+			return
+		case "reconcile":
+			runReconcile(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "manifest":
+			runManifest(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		}
+	}
+
+	fs := flag.NewFlagSet("sh-backups", flag.ExitOnError)
+	overrides := configFlags(fs)
+	var upload, deleteAtQuota, forceDelete bool
+	fs.BoolVar(&upload, "upload", false, "upload the latest backup of each job")
+	fs.BoolVar(&upload, "U", false, "shorthand for -upload")
+	fs.BoolVar(&deleteAtQuota, "delete", false, "delete remote backups once the quota is reached")
+	fs.BoolVar(&deleteAtQuota, "D", false, "shorthand for -delete")
+	fs.BoolVar(&forceDelete, "force-delete", false, "delete all remote backups")
+	fs.BoolVar(&forceDelete, "FD", false, "shorthand for -force-delete")
+	fs.BoolVar(&assumeYes, "yes", false, "do not ask for confirmation before -force-delete")
+	var rotate bool
+	fs.BoolVar(&rotate, "rotate", false, "upload the latest backup, then delete the oldest remote backups until under quota")
+	fs.BoolVar(&rotate, "R", false, "shorthand for -rotate")
+	jobName := fs.String("job", "", "run only the named job (default: every job)")
+	_ = fs.Parse(os.Args[1:])
+
+	var operation string
+	switch {
+	case upload:
+		operation = "upload"
+	case deleteAtQuota:
+		operation = "delete"
+	case forceDelete:
+		operation = "force-delete"
+	case rotate:
+		operation = "rotate"
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	runid.StartRun()
+
 	// Step 1: Load config
-	cfg := config.Load()
+	cfg := loadConfig(*overrides)
+	jobs := selectJobs(cfg, *jobName)
 
 	// Step 2: Create API client
-	apiBaseURL := cfg.APIBaseUrl // or from config if available
-	apiClient := api.NewAPIClient(apiBaseURL, cfg.APIKey)
+	apiClient := newAPIClient(cfg)
 
-	// Step 3: Get company by API key using API client
-	company, err := apiClient.FindCompanyByAPIKey(cfg.APIKey)
+	// Step 3: Run the operation for every job
+	exit(runJobs(cfg, apiClient, jobs, operation))
+}
+
+func exit(err error) {
+	logger.Close()
 	if err != nil {
-		logger.Error("Failed to fetch company", err)
 		os.Exit(1)
 	}
+	os.Exit(0)
+}
+
+// configFlags registers the flags that override configuration values.
+func configFlags(fs *flag.FlagSet) *config.Overrides {
+	o := &config.Overrides{}
+	fs.StringVar(&o.ConfigPath, "config", "", "path to the JSON config file")
+	fs.StringVar(&o.APIBaseURL, "api-base-url", "", "backend API base URL")
+	fs.StringVar(&o.LocalFolderPath, "local-folder", "", "folder holding the Tally backups")
+	fs.StringVar(&o.LogLevel, "log-level", "", "log level: debug, info, warn or error")
+	return o
+}
+
+// loadConfig loads the configuration, printing every problem and exiting
+// when it is invalid.
+func loadConfig(o config.Overrides) config.AppConfig {
+	cfg, err := config.Load(o)
+	if err != nil {
+		logger.Error("Invalid configuration", err)
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	initLogging(cfg, true)
+	return cfg
+}
+
+// initLogging opens the log files configured in cfg. If they cannot be
+// opened the run continues, logging warnings and errors to stderr only.
+func initLogging(cfg config.AppConfig, console bool) {
+	logger.AddSecret(cfg.APIKey, cfg.Network.ProxyPassword)
+	for _, d := range cfg.Destinations {
+		logger.AddSecret(d.SecretAccessKey)
+	}
+	l := cfg.Logging
+	err := logger.Init(logger.Options{
+		Dir:     l.Dir,
+		Level:   l.Level,
+		Format:  l.Format,
+		Console: console && l.ConsoleEnabled(),
+		Rotation: logger.Rotation{
+			MaxSizeMB:   l.MaxSizeMB,
+			RotateEvery: l.RotateEvery.Duration,
+			MaxBackups:  l.MaxBackups,
+			Compress:    l.Compress != nil && *l.Compress,
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open log files, logging to the console only: %v\n", err)
+	}
+}
 
-	if company.TotalUsageQuota == company.UsedQuota {
-		logger.Error("Company has reached its usage quota", nil)
+func newAPIClient(cfg config.AppConfig) *api.APIClient {
+	apiClient, err := api.NewAPIClientFromConfig(cfg)
+	if err != nil {
+		logger.Error("Invalid network configuration", err)
 		os.Exit(1)
 	}
+	return apiClient
+}
 
-	currentTime := func() string {
-		return time.Now().Format(time.RFC3339)
+func selectJobs(cfg config.AppConfig, name string) []config.Job {
+	if name == "" {
+		return cfg.Jobs
+	}
+	job, ok := cfg.Job(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "No job named %q in the configuration\n", name)
+		os.Exit(2)
 	}
+	return []config.Job{job}
+}
 
-	// Check for --register flag
-	for _, arg := range os.Args {
-		if arg == "--upload" || arg == "-U" || arg == "" {
-			logger.Info(fmt.Sprintf("Uploading Operation Started at %s...", currentTime()))
-			handleFileUpload(apiClient, company, cfg.LocalFolderPath)
-			logger.Info(fmt.Sprintf("Uploading Operation Completed at %s...", currentTime()))
-			os.Exit(0)
-		}
+// runJobs fetches the company and runs one operation for each job,
+// continuing past failures. It records the run summary in the history and
+// returns the last error.
+func runJobs(cfg config.AppConfig, backend api.Backend, jobs []config.Job, operation string) error {
+	start := time.Now()
+	host, _ := os.Hostname()
+	summary := &models.RunSummary{
+		RunId:     runid.Current().Run,
+		Host:      host,
+		Operation: operation,
+		StartedAt: start.Format(time.RFC3339),
+	}
 
-		if arg == "--delete" || arg == "-D" {
-			logger.Info(fmt.Sprintf("Deletion Operation Started %s...", currentTime()))
-			handleFileDelete(apiClient, company, true)
-			logger.Info(fmt.Sprintf("Deletion Operation Completed at %s...", currentTime()))
-			os.Exit(0)
+	var lastErr error
+	// Fetch the company on every run so quota changes are picked up.
+	company, err := backend.FindCompanyByAPIKey(cfg.APIKey)
+	if err != nil {
+		logger.Error("Failed to fetch company", err)
+		summary.Errors = append(summary.Errors, err.Error())
+		lastErr = err
+	} else {
+		summary.CompanyId = company.Id
+		for _, job := range jobs {
+			stats, err := runOperation(cfg, backend, company, job, operation)
+			summary.Jobs = append(summary.Jobs, job.Name)
+			summary.FilesConsidered += stats.FilesConsidered
+			summary.FilesUploaded += stats.FilesUploaded
+			summary.FilesSkipped += stats.FilesSkipped
+			summary.FilesDeleted += stats.FilesDeleted
+			summary.BytesUploaded += stats.BytesUploaded
+			summary.BytesDeleted += stats.BytesDeleted
+			summary.FilesMirrored += stats.FilesMirrored
+			summary.BytesMirrored += stats.BytesMirrored
+			summary.FilesVerified += stats.FilesVerified
+			if err != nil {
+				summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %v", job.Name, err))
+				lastErr = err
+			}
 		}
+		// Every job shares the company's folder, so one reconcile covers
+		// them all. Verifying stores nothing, so there is nothing to
+		// reconcile after it.
+		if cfg.Quota.ReconcileEnabled() && len(jobs) > 0 && operation != "verify" {
+			if _, err := newPipeline(cfg, backend, company, jobs[0]).Reconcile(true); err != nil {
+				summary.Errors = append(summary.Errors, fmt.Sprintf("reconcile: %v", err))
+			}
+		}
+	}
 
-		if arg == "--force-delete" || arg == "-FD" {
-			logger.Info(fmt.Sprintf("Force Deletion Operation Started at %s...", currentTime()))
-			handleFileDelete(apiClient, company, false)
-			logger.Info(fmt.Sprintf("Force Deletion Operation Completed at %s...", currentTime()))
-			os.Exit(0)
+	finished := time.Now()
+	summary.FinishedAt = finished.Format(time.RFC3339)
+	summary.DurationMs = finished.Sub(start).Milliseconds()
+	if lastErr != nil {
+		summary.ExitStatus = 1
+	}
+	recordRun(cfg, backend, summary)
+	return lastErr
+}
+
+// recordRun appends the summary to the history file and, if configured,
+// reports it to the backend. Failures are logged but do not fail the run.
+func recordRun(cfg config.AppConfig, backend api.Backend, summary *models.RunSummary) {
+	if err := history.Append(historyPath(cfg), summary); err != nil {
+		logger.Error("Failed to write run history", err, "file", historyPath(cfg))
+	}
+	if cfg.History.ReportEnabled() {
+		if err := backend.InsertRunSummary(summary); err != nil {
+			logger.Error("Failed to report run summary", err)
 		}
 	}
+	logger.Info("Run summary",
+		"operation", summary.Operation,
+		"files_considered", summary.FilesConsidered,
+		"files_uploaded", summary.FilesUploaded,
+		"files_skipped", summary.FilesSkipped,
+		"files_deleted", summary.FilesDeleted,
+		"bytes_uploaded", summary.BytesUploaded,
+		"bytes_deleted", summary.BytesDeleted,
+		"files_mirrored", summary.FilesMirrored,
+		"bytes_mirrored", summary.BytesMirrored,
+		"files_verified", summary.FilesVerified,
+		"errors", len(summary.Errors),
+		"exit_status", summary.ExitStatus)
 }
 
-func handleFileUpload(apiClient *api.APIClient, company *models.Company, localFolder string) error {
-	// Assume pattern is "Tally" and extension is ".zip"
+func historyPath(cfg config.AppConfig) string {
+	if cfg.History.File != "" {
+		return cfg.History.File
+	}
+	return history.DefaultPath()
+}
 
-	localZipPath, fileSize, err := utils.FindZipFileWithPatternAndLatestDate(localFolder)
-	if err != nil || fileSize == 0 {
-		logger.Error("Failed to find latest Tally file or filesize is 0", err)
-		return nil
+// newPipeline builds the backup pipeline for job with the configured
+// retention policy and safeguards.
+func newPipeline(cfg config.AppConfig, backend api.Backend, company *models.Company, job config.Job) *backup.Pipeline {
+	pipeline := backup.New(backend, company)
+	pipeline.Job = job.Name
+	pipeline.Retention = retention(cfg.Retention)
+	pipeline.Safeguards = backup.Safeguards{
+		KeepRecent:     time.Duration(cfg.Safeguards.KeepRecentDays) * 24 * time.Hour,
+		Trash:          cfg.Safeguards.TrashEnabled(),
+		TrashRetention: cfg.Safeguards.TrashRetention.Duration,
+	}
+	pipeline.Confirm = confirmDelete
+	pipeline.Ledger = openLedger(cfg)
+	if cfg.Staging.StagingEnabled() {
+		pipeline.StagingDir = stagingDir(cfg)
+	}
+	pipeline.Chunked = job.Chunked
+	pipeline.Version = toolVersion()
+	pipeline.Destinations = destinations(cfg, job)
+	if m := job.Mirror; m != nil {
+		pipeline.Mirror = &backup.Mirror{Dir: m.Path, Retention: retention(m.Retention)}
 	}
+	return pipeline
+}
 
-	uploadKey := filepath.Base(localZipPath)
-	// Step 5: Upload .zip file from local folder
-	err = apiClient.UploadFile(company.CompanyApiKey, localZipPath)
-	if err != nil {
-		logger.Error("Failed to upload file to S3", err)
-		return err
+func retention(r config.RetentionConfig) backup.Retention {
+	return backup.Retention{
+		KeepLast:    r.KeepLast,
+		KeepDaily:   r.KeepDaily,
+		KeepWeekly:  r.KeepWeekly,
+		KeepMonthly: r.KeepMonthly,
+		MaxAge:      r.MaxAge.Duration,
 	}
-	logger.Info(fmt.Sprintf("Uploaded file to S3: %s", uploadKey))
+}
 
-	// Get file size
-	info, err := os.Stat(localZipPath)
+// destinations builds the extra upload destinations of job. The config has
+// been validated, so only an unusable network setup can fail here.
+func destinations(cfg config.AppConfig, job config.Job) []storage.Destination {
+	if len(job.Destinations) == 0 {
+		return nil
+	}
+	client, err := api.NewHTTPClient(cfg.Network)
 	if err != nil {
-		logger.Error("Failed to stat uploaded file", err)
-		return err
+		logger.Error("Invalid network configuration", err)
+		os.Exit(1)
+	}
+	var dests []storage.Destination
+	for _, name := range job.Destinations {
+		dc, _ := cfg.Destination(name)
+		d, err := storage.New(dc, client)
+		if err != nil {
+			logger.Error("Invalid destination", err, "job", job.Name)
+			os.Exit(1)
+		}
+		dests = append(dests, d)
 	}
-	size := info.Size()
+	return dests
+}
 
-	// Insert upload metadata using API client
-	meta := &models.FileMetadata{
-		Id:          uuid.NewString(),
-		CreatedAt:   time.Now().Format(time.RFC3339),
-		FileName:    filepath.Base(localZipPath),
-		FileSize:    &size,
-		FileKey:     uploadKey,
-		CompanyId:   company.Id,
-		FileTxnType: utils.PtrInt16(1), // 1 = upload
-		FileTxnMeta: "Uploaded to S3",
+func stagingDir(cfg config.AppConfig) string {
+	if cfg.Staging.Dir != "" {
+		return cfg.Staging.Dir
 	}
-	if err := apiClient.InsertFileMetadata(meta); err != nil {
-		logger.Error("Failed to insert upload metadata", err)
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, "sh_backups", "staging")
 	}
+	return filepath.Join(os.TempDir(), "sh_backups-staging")
+}
 
-	// Update company quota using API client
-	updateQuota := &models.UpdateUsageQuota{
-		UsedQuota:   size,
-		FileTxnType: 1, // 1 = upload
+// openLedger loads the quota ledger. An unreadable ledger is left out; the
+// next reconcile rebuilds it.
+func openLedger(cfg config.AppConfig) *ledger.Ledger {
+	path := cfg.Quota.LedgerFile
+	if path == "" {
+		path = ledger.DefaultPath()
 	}
-	err = apiClient.UpdateCompanyQuota(updateQuota)
+	l, err := ledger.Load(path)
 	if err != nil {
-		logger.Error("Failed to update company quota", err)
+		logger.Warn("Cannot read quota ledger, it will be rebuilt", "file", path, "error", err)
+		return nil
 	}
-	return nil
+	return l
 }
 
-func handleFileDelete(apiClient *api.APIClient, company *models.Company, applyCondition bool) {
-	companyFolder := company.CompanyName
-	folderInfo, _ := apiClient.GetFolderSize(company.CompanyApiKey, locTag)
-	contentSize := folderInfo.TotalSize
-	var appliedCondition bool
-	if applyCondition {
-		appliedCondition = contentSize >= *company.TotalUsageQuota
-	} else {
-		appliedCondition = true
+// assumeYes skips the confirmation before deleting every remote backup.
+var assumeYes bool
+
+// version is set at build time with -ldflags "-X main.version=1.4.0".
+var version string
+
+// toolVersion is the version recorded in backup manifests, falling back to
+// the module version stamped by go build.
+func toolVersion() string {
+	if version != "" {
+		return version
 	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "dev"
+}
 
-	if appliedCondition {
-		dErr := apiClient.DeleteFiles(company.CompanyApiKey, locTag)
-		if dErr != nil {
-			logger.Error("Cannot delete files", dErr)
-			return
-		}
-		meta := &models.FileMetadata{
-			Id:          uuid.NewString(),
-			CreatedAt:   time.Now().Format(time.RFC3339),
-			FileName:    companyFolder,
-			FileSize:    &contentSize,
-			FileKey:     companyFolder + "/" + locTag + "/",
-			CompanyId:   company.Id,
-			FileTxnType: utils.PtrInt16(2), // 2 = delete
-			FileTxnMeta: "Deleted files in S3",
-		}
-		if err := apiClient.InsertFileMetadata(meta); err != nil {
-			logger.Error("Failed to insert upload metadata", err)
-		}
+// confirmDelete lists the backups about to be deleted and asks on the
+// terminal. Without a terminal it declines unless -yes was given.
+func confirmDelete(files []models.RemoteFile) bool {
+	if assumeYes {
+		return true
+	}
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	fmt.Printf("About to delete %d remote backups (%d bytes):\n", len(files), total)
+	for _, f := range files {
+		fmt.Printf("  %s\n", f.FileName)
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		fmt.Fprintln(os.Stderr, "Not running in a terminal; pass -yes to delete without confirmation")
+		return false
+	}
+	fmt.Print("Type 'yes' to continue: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
 
-		updateQuota := &models.UpdateUsageQuota{
-			UsedQuota:   int64(0),
-			FileTxnType: 2, // 2 = delete
-		}
-		qErr := apiClient.UpdateCompanyQuota(updateQuota)
-		if qErr != nil {
-			logger.Error("Failed to update company quota", qErr)
+func runOperation(cfg config.AppConfig, backend api.Backend, company *models.Company, job config.Job, operation string) (backup.Stats, error) {
+	runid.StartOperation()
+	defer runid.EndOperation()
+	pipeline := newPipeline(cfg, backend, company, job)
+	start := time.Now()
+	logger.Info("Operation started", "operation", operation, "job", job.Name)
+
+	var err error
+	switch operation {
+	case "upload":
+		if err = pipeline.CheckQuota(); err != nil {
+			logger.Error("Company has reached its usage quota", err, "job", job.Name)
+			pipeline.Stats.FilesSkipped++
+			break
 		}
-		logger.Info(fmt.Sprintf("Deleted Folder Contents with Tally backups...%dMB", (contentSize / 1024 / 1024)))
+		err = pipeline.Upload(job.LocalFolderPath)
+	case "rotate":
+		// Rotation frees space itself, so it runs even at quota.
+		err = pipeline.Rotate(job.LocalFolderPath)
+	case "delete":
+		err = pipeline.Delete(true)
+	case "force-delete":
+		err = pipeline.Delete(false)
+	case "verify":
+		err = pipeline.Verify(cfg.Verify.Sample)
+	default:
+		err = fmt.Errorf("unknown operation %q", operation)
+	}
+
+	duration := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		logger.Error("Operation failed", err, "operation", operation, "job", job.Name, "duration", duration)
 	} else {
-		logger.Info(fmt.Sprintf("Under valid quota usage...%d MB", (contentSize / 1024 / 1024)))
+		logger.Info("Operation completed", "operation", operation, "job", job.Name, "duration", duration)
 	}
+	return pipeline.Stats, err
 }
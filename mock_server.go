@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/mockserver"
+)
+
+// runMockServer serves an offline stand-in for the backend and S3 so the
+// upload, delete and quota flows can be exercised without production.
+func runMockServer(args []string) {
+	fs := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "address to listen on")
+	dir := fs.String("dir", "mock-s3", "local directory backing the mock bucket")
+	apiKey := fs.String("api-key", "mock-api-key", "API key accepted by the mock backend")
+	company := fs.String("company", "Mock Company", "name of the mock company")
+	quota := fs.Int64("quota", 1<<30, "total usage quota in bytes")
+	_ = fs.Parse(args)
+	if err := logger.Init(logger.Options{Console: true}); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open log files: %v\n", err)
+	}
+
+	srv, err := mockserver.New(mockserver.Options{
+		APIKey:          *apiKey,
+		CompanyName:     *company,
+		TotalUsageQuota: *quota,
+		StorageDir:      *dir,
+	})
+	if err != nil {
+		logger.Error("Failed to start mock server", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Mock backend listening on http://%s (bucket dir %s)\n", *addr, *dir)
+	fmt.Printf("Use API_BASE_URL=http://%s API_KEY=%s\n", *addr, *apiKey)
+	logger.Info("Mock server listening", "addr", *addr, "dir", *dir)
+	if err := http.ListenAndServe(*addr, srv); err != nil {
+		logger.Error("Mock server stopped", err)
+		os.Exit(1)
+	}
+}
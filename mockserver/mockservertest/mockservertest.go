@@ -0,0 +1,46 @@
+// Package mockservertest starts the mock backend for tests, so every
+// package tests against the same stand-in rather than its own setup.
+package mockservertest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/mockserver"
+)
+
+// APIKey is the API key the mock backend accepts unless the options set one.
+const APIKey = "test-api-key"
+
+// Backend is a mock backend served over HTTP for the length of a test.
+type Backend struct {
+	*mockserver.Server
+	// URL is the base URL of the backend, e.g. http://127.0.0.1:1234.
+	URL string
+	// Client talks to the backend with its API key.
+	Client *api.APIClient
+}
+
+// New starts a mock backend configured by opts and closes it when the test
+// ends. The API key defaults to APIKey, the bucket to a temporary directory
+// and the quota to 1 GiB.
+func New(t testing.TB, opts mockserver.Options) *Backend {
+	t.Helper()
+	if opts.APIKey == "" {
+		opts.APIKey = APIKey
+	}
+	if opts.StorageDir == "" {
+		opts.StorageDir = t.TempDir()
+	}
+	if opts.TotalUsageQuota == 0 {
+		opts.TotalUsageQuota = 1 << 30
+	}
+	srv, err := mockserver.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return &Backend{Server: srv, URL: ts.URL, Client: api.NewAPIClient(ts.URL, opts.APIKey)}
+}
@@ -0,0 +1,256 @@
+package mockserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+const amzDateFormat = "20060102T150405Z"
+
+// uploadPolicy is the subset of an S3 POST policy the mock enforces.
+type uploadPolicy struct {
+	Expiration string `json:"expiration"`
+	Key        string `json:"key"`
+	MaxSize    int64  `json:"max_size"`
+}
+
+func (s *Server) handlePresignUpload(w http.ResponseWriter, r *http.Request) {
+	var req models.PresignUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid presign request: "+err.Error())
+		return
+	}
+	if req.FileName == "" || req.ContentSize <= 0 {
+		writeDetail(w, http.StatusUnprocessableEntity, "file_name and a positive content_size are required")
+		return
+	}
+
+	// A rotation may go over quota until it deletes the backups it
+	// replaces, but the new backup must fit in the quota by itself.
+	company := s.Company()
+	usage := *company.UsedQuota + req.ContentSize
+	if req.Rotate {
+		usage = req.ContentSize
+	}
+	if company.TotalUsageQuota != nil && usage > *company.TotalUsageQuota {
+		writeDetail(w, http.StatusForbidden, "Upload would exceed the usage quota")
+		return
+	}
+
+	now := time.Now().UTC()
+	policy := uploadPolicy{
+		Expiration: now.Add(s.opts.PresignTTL).Format(time.RFC3339),
+		Key:        s.folderKey(locTagOf(req.LocTag)) + "/" + filepath.Base(req.FileName),
+		MaxSize:    req.ContentSize,
+	}
+	raw, _ := json.Marshal(policy)
+	encoded := base64.StdEncoding.EncodeToString(raw)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	writeJSON(w, http.StatusOK, models.PresignedUploadResponse{
+		URL: fmt.Sprintf("%s://%s%s", scheme, r.Host, uploadPath),
+		Fields: map[string]string{
+			"key":              policy.Key,
+			"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+			"x-amz-credential": "MOCKACCESSKEY/" + now.Format("20060102") + "/us-east-1/s3/aws4_request",
+			"x-amz-date":       now.Format(amzDateFormat),
+			"policy":           encoded,
+			"x-amz-signature":  s.sign(encoded),
+			"Content-Type":     "application/zip",
+		},
+	})
+}
+
+// handleS3Upload accepts an S3-style presigned multipart POST and stores the
+// file under StorageDir at the policy's key.
+func (s *Server) handleS3Upload(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedPOSTRequest", err.Error())
+		return
+	}
+
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "POST requires exactly one file upload per request")
+			return
+		}
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "MalformedPOSTRequest", err.Error())
+			return
+		}
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, 1<<20))
+			if err != nil {
+				writeS3Error(w, http.StatusBadRequest, "MalformedPOSTRequest", err.Error())
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		// Like S3, every form field must precede the file.
+		policy, status, code, msg := s.checkPolicy(fields)
+		if status != 0 {
+			writeS3Error(w, status, code, msg)
+			return
+		}
+		if err := s.storeObject(policy, part); err != nil {
+			writeS3Error(w, http.StatusBadRequest, "EntityTooLarge", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
+
+func (s *Server) checkPolicy(fields map[string]string) (*uploadPolicy, int, string, string) {
+	encoded := fields["policy"]
+	if encoded == "" || !hmac.Equal([]byte(s.sign(encoded)), []byte(fields["x-amz-signature"])) {
+		return nil, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match"
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, http.StatusBadRequest, "InvalidPolicyDocument", err.Error()
+	}
+	var policy uploadPolicy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return nil, http.StatusBadRequest, "InvalidPolicyDocument", err.Error()
+	}
+	expiry, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil || time.Now().After(expiry) {
+		return nil, http.StatusForbidden, "AccessDenied", "Invalid according to Policy: Policy expired"
+	}
+	if fields["key"] != policy.Key {
+		return nil, http.StatusForbidden, "AccessDenied", "Invalid according to Policy: key does not match"
+	}
+	return &policy, 0, "", ""
+}
+
+func (s *Server) storeObject(policy *uploadPolicy, src io.Reader) error {
+	dest, err := s.objectPath(policy.Key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(src, policy.MaxSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n > policy.MaxSize {
+		return fmt.Errorf("your proposed upload exceeds the maximum allowed size of %d bytes", policy.MaxSize)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// handlePresignDownload returns a presigned GET URL for one of the
+// company's objects.
+func (s *Server) handlePresignDownload(w http.ResponseWriter, r *http.Request) {
+	var req models.PresignDownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid presign request: "+err.Error())
+		return
+	}
+	key := path.Clean(req.FileKey)
+	file, err := s.objectPath(key)
+	if err != nil || !strings.HasPrefix(key, s.company.CompanySlug+"/") {
+		writeDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("Key %q is not under %s", req.FileKey, s.company.CompanySlug))
+		return
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		writeDetail(w, http.StatusNotFound, fmt.Sprintf("Key %q not found", req.FileKey))
+		return
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(s.opts.PresignTTL).Unix(), 10)
+	query := url.Values{
+		"key":             {key},
+		"x-amz-expires":   {expires},
+		"x-amz-signature": {s.sign(key + "\n" + expires)},
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	writeJSON(w, http.StatusOK, models.PresignedDownloadResponse{
+		URL: fmt.Sprintf("%s://%s%s?%s", scheme, r.Host, downloadPath, query.Encode()),
+	})
+}
+
+// handleS3Download serves an object for a presigned GET URL.
+func (s *Server) handleS3Download(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, expires := q.Get("key"), q.Get("x-amz-expires")
+	if !hmac.Equal([]byte(s.sign(key+"\n"+expires)), []byte(q.Get("x-amz-signature"))) {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match")
+		return
+	}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied", "Request has expired")
+		return
+	}
+	file, err := s.objectPath(key)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, filepath.Base(file), info.ModTime(), f)
+}
+
+func (s *Server) sign(policy string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(policy))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeS3Error mimics the XML error documents returned by S3.
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>%s</Message></Error>", code, html.EscapeString(message))
+}
@@ -0,0 +1,208 @@
+package mockserver_test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shreshtasmg.in/sh_backups/mockserver"
+	"shreshtasmg.in/sh_backups/mockserver/mockservertest"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// postForm posts an S3 multipart upload of fields, in order, followed by a
+// file part unless file is nil, and returns the response status and body.
+func postForm(t *testing.T, url string, fields [][2]string, file []byte) (int, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, f := range fields {
+		if err := w.WriteField(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if file != nil {
+		part, err := w.CreateFormFile("file", "backup.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, w.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+// formFields returns the presigned fields in the order the client sends
+// them, with overrides applied.
+func formFields(p *models.PresignedUploadResponse, overrides map[string]string) [][2]string {
+	var out [][2]string
+	for _, name := range []string{"key", "x-amz-algorithm", "x-amz-credential", "x-amz-date", "policy", "x-amz-signature", "Content-Type"} {
+		value := p.Fields[name]
+		if v, ok := overrides[name]; ok {
+			value = v
+		}
+		out = append(out, [2]string{name, value})
+	}
+	return out
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	path := writeFile(t, "Tallybackupason01032026.zip", "backup contents")
+	if err := b.Client.UploadFile(mockservertest.APIKey, path); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	files, err := b.Client.ListFiles(mockservertest.APIKey, "TallyBackups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].FileName != "Tallybackupason01032026.zip" || files[0].Size != int64(len("backup contents")) {
+		t.Fatalf("listing = %+v, want the uploaded backup", files)
+	}
+	if want := b.Company().CompanySlug + "/TallyBackups/Tallybackupason01032026.zip"; files[0].Key != want {
+		t.Errorf("key = %q, want %q", files[0].Key, want)
+	}
+
+	var got bytes.Buffer
+	if err := b.Client.DownloadFile(mockservertest.APIKey, files[0].Key, &got); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	if got.String() != "backup contents" {
+		t.Errorf("downloaded %q, want the uploaded contents", got.String())
+	}
+	if err := b.Client.DownloadFile(mockservertest.APIKey, "other-company/TallyBackups/x.zip", io.Discard); err == nil {
+		t.Error("download of another company's key succeeded")
+	}
+}
+
+func TestPresignRejectsUploadOverQuota(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{TotalUsageQuota: 10})
+	if _, err := b.Client.GeneratePresignURL(mockservertest.APIKey, writeFile(t, "small.zip", "0123456789")); err != nil {
+		t.Errorf("presign of an upload that fills the quota: %v", err)
+	}
+	_, err := b.Client.GeneratePresignURL(mockservertest.APIKey, writeFile(t, "large.zip", "0123456789A"))
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("presign over quota = %v, want a 403", err)
+	}
+}
+
+func TestS3UploadChecksPolicy(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	path := writeFile(t, "Tallybackupason01032026.zip", "0123456789")
+	presigned, err := b.Client.GeneratePresignURL(mockservertest.APIKey, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		file      []byte
+		status    int
+		code      string
+	}{
+		{"tampered signature", map[string]string{"x-amz-signature": strings.Repeat("0", 64)}, []byte("0123456789"), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"tampered policy", map[string]string{"policy": presigned.Fields["policy"] + "="}, []byte("0123456789"), http.StatusForbidden, "SignatureDoesNotMatch"},
+		{"other key", map[string]string{"key": presigned.Fields["key"] + ".exe"}, []byte("0123456789"), http.StatusForbidden, "AccessDenied"},
+		{"larger than presigned", nil, []byte("0123456789A"), http.StatusBadRequest, "EntityTooLarge"},
+		{"no file", nil, nil, http.StatusBadRequest, "InvalidArgument"},
+		{"valid", nil, []byte("0123456789"), http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postForm(t, presigned.URL, formFields(presigned, tt.overrides), tt.file)
+			if status != tt.status || !strings.Contains(body, tt.code) {
+				t.Errorf("status %d, body %q; want %d with %s", status, body, tt.status, tt.code)
+			}
+		})
+	}
+	files, err := b.Client.ListFiles(mockservertest.APIKey, "TallyBackups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Size != 10 {
+		t.Errorf("listing = %+v, want only the valid upload", files)
+	}
+}
+
+func TestS3UploadRejectsFieldsAfterFile(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	presigned, err := b.Client.GeneratePresignURL(mockservertest.APIKey, writeFile(t, "a.zip", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file comes before the signature, so the policy cannot be checked.
+	fields := formFields(presigned, nil)
+	status, body := postForm(t, presigned.URL, fields[:4], []byte("data"))
+	if status != http.StatusForbidden || !strings.Contains(body, "SignatureDoesNotMatch") {
+		t.Errorf("status %d, body %q; want a signature mismatch", status, body)
+	}
+}
+
+func TestS3UploadRejectsExpiredPolicy(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{PresignTTL: time.Nanosecond})
+	presigned, err := b.Client.GeneratePresignURL(mockservertest.APIKey, writeFile(t, "a.zip", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := postForm(t, presigned.URL, formFields(presigned, nil), []byte("data"))
+	if status != http.StatusForbidden || !strings.Contains(body, "Policy expired") {
+		t.Errorf("status %d, body %q; want an expired policy", status, body)
+	}
+}
+
+// In-progress uploads are hidden from listings and folder sizes.
+func TestListingSkipsPartialUploads(t *testing.T) {
+	dir := t.TempDir()
+	b := mockservertest.New(t, mockserver.Options{StorageDir: dir})
+	if err := b.Client.UploadFile(mockservertest.APIKey, writeFile(t, "a.zip", "data")); err != nil {
+		t.Fatal(err)
+	}
+	partial := dir + "/" + b.Company().CompanySlug + "/TallyBackups/.upload-123"
+	if err := os.WriteFile(partial, []byte("partial upload"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files, err := b.Client.ListFiles(mockservertest.APIKey, "TallyBackups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := b.Client.GetFolderSize(mockservertest.APIKey, "TallyBackups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || size.TotalSize != 4 {
+		t.Errorf("listing = %+v, size = %d; want only the finished upload", files, size.TotalSize)
+	}
+}
+
+// A rotation may go over quota, but not with a file larger than the quota.
+func TestPresignLetsRotationOvershootQuota(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{TotalUsageQuota: 10})
+	if err := b.Client.UploadFile(mockservertest.APIKey, writeFile(t, "old.zip", "0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Client.UpdateCompanyQuota(&models.UpdateUsageQuota{UsedQuota: 10, FileTxnType: models.TxnUpload}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Client.UploadFileTo(mockservertest.APIKey, "TallyBackups", writeFile(t, "new.zip", "0123456789")); err == nil {
+		t.Error("upload at quota succeeded")
+	}
+	if err := b.Client.RotateFileTo(mockservertest.APIKey, "TallyBackups", writeFile(t, "new.zip", "0123456789")); err != nil {
+		t.Errorf("rotation at quota: %v", err)
+	}
+	if err := b.Client.RotateFileTo(mockservertest.APIKey, "TallyBackups", writeFile(t, "large.zip", "0123456789A")); err == nil {
+		t.Error("rotation of a file larger than the quota succeeded")
+	}
+}
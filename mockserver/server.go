@@ -0,0 +1,425 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"shreshtasmg.in/sh_backups/logger"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/utils"
+)
+
+const (
+	defaultLocTag = "TallyBackups"
+	uploadPath    = "/s3/upload"
+	downloadPath  = "/s3/download"
+)
+
+// Options configures the single company served by the mock backend.
+type Options struct {
+	APIKey          string
+	CompanyName     string
+	TotalUsageQuota int64
+	// StorageDir is the local directory standing in for the S3 bucket.
+	StorageDir string
+	// PresignTTL is how long presigned upload policies and download URLs
+	// stay valid.
+	PresignTTL time.Duration
+}
+
+// Server is an offline stand-in for the backup backend and the S3 bucket
+// behind its presigned POST uploads.
+type Server struct {
+	mu      sync.Mutex
+	opts    Options
+	secret  []byte
+	company models.Company
+	files   []models.FileMetadata
+	runs    []models.RunSummary
+	// protected holds the object keys under legal hold.
+	protected map[string]bool
+	mux       *http.ServeMux
+}
+
+func New(opts Options) (*Server, error) {
+	if opts.APIKey == "" {
+		return nil, errors.New("mock server needs an API key")
+	}
+	if opts.StorageDir == "" {
+		return nil, errors.New("mock server needs a storage directory")
+	}
+	if opts.CompanyName == "" {
+		opts.CompanyName = "Mock Company"
+	}
+	if opts.PresignTTL <= 0 {
+		opts.PresignTTL = 15 * time.Minute
+	}
+	if err := os.MkdirAll(opts.StorageDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage dir: %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s := &Server{
+		opts:      opts,
+		secret:    []byte(uuid.NewString()),
+		protected: map[string]bool{},
+		company: models.Company{
+			Id:              uuid.NewString(),
+			CreatedAt:       &models.CustomTime{Time: now},
+			CompanyName:     opts.CompanyName,
+			CompanySlug:     utils.Slugify(opts.CompanyName),
+			CompanyApiKey:   opts.APIKey,
+			StartDate:       &models.CustomTime{Time: now},
+			EndDate:         &models.CustomTime{Time: now.AddDate(1, 0, 0)},
+			TotalUsageQuota: utils.PtrInt64(opts.TotalUsageQuota),
+			UsedQuota:       utils.PtrInt64(0),
+		},
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /api/companies/by-api-key", s.authorized(s.handleCompany))
+	s.mux.HandleFunc("POST /api/filemeta", s.authorized(s.handleInsertFileMeta))
+	s.mux.HandleFunc("PATCH /api/companies/quota", s.authorized(s.handleQuota))
+	s.mux.HandleFunc("POST /api/companies/generate/presigned/url/upload", s.authorized(s.handlePresignUpload))
+	s.mux.HandleFunc("GET /api/filemeta/folder/size", s.authorized(s.handleFolderSize))
+	s.mux.HandleFunc("GET /api/filemeta/folder/list", s.authorized(s.handleFolderList))
+	s.mux.HandleFunc("POST /api/companies/delete/files", s.authorized(s.handleDeleteFiles))
+	s.mux.HandleFunc("POST /api/companies/delete/file/keys", s.authorized(s.handleDeleteFileKeys))
+	s.mux.HandleFunc("POST /api/runs", s.authorized(s.handleInsertRun))
+	s.mux.HandleFunc("POST /api/filemeta/protect", s.authorized(s.handleProtect))
+	s.mux.HandleFunc("GET /api/filemeta/trash/list", s.authorized(s.handleTrashList))
+	s.mux.HandleFunc("POST /api/companies/trash/files", s.authorized(s.handleTrashFiles))
+	s.mux.HandleFunc("POST /api/companies/trash/restore", s.authorized(s.handleTrashRestore))
+	s.mux.HandleFunc("POST /api/companies/trash/purge", s.authorized(s.handleTrashPurge))
+	s.mux.HandleFunc("POST /api/companies/generate/presigned/url/download", s.authorized(s.handlePresignDownload))
+	s.mux.HandleFunc("POST "+uploadPath, s.handleS3Upload)
+	s.mux.HandleFunc("GET "+downloadPath, s.handleS3Download)
+	return s, nil
+}
+
+// ServeHTTP logs the client's correlation headers, like the backend does,
+// and echoes X-Request-Id on the response.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID != "" {
+		w.Header().Set("X-Request-Id", requestID)
+	}
+	logger.Info("Mock request", "method", r.Method, "path", r.URL.Path, "request_id", requestID, "traceparent", r.Header.Get("Traceparent"))
+	s.mux.ServeHTTP(w, r)
+}
+
+// Company returns a snapshot of the served company, including its quota.
+func (s *Server) Company() models.Company {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.company
+	c.UsedQuota = utils.PtrInt64(*s.company.UsedQuota)
+	return c
+}
+
+// SetTotalUsageQuota changes the company's total quota, as an operator
+// changing the plan would.
+func (s *Server) SetTotalUsageQuota(quota int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.company.TotalUsageQuota = utils.PtrInt64(quota)
+}
+
+// FileMetadata returns every metadata record inserted so far.
+func (s *Server) FileMetadata() []models.FileMetadata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.FileMetadata(nil), s.files...)
+}
+
+// Runs returns every run summary reported so far.
+func (s *Server) Runs() []models.RunSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.RunSummary(nil), s.runs...)
+}
+
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Company-Api-Key") != s.opts.APIKey {
+			writeDetail(w, http.StatusUnauthorized, "Invalid or missing API key")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleCompany(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Company())
+}
+
+func (s *Server) handleInsertFileMeta(w http.ResponseWriter, r *http.Request) {
+	var meta models.FileMetadata
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid file metadata: "+err.Error())
+		return
+	}
+	if meta.Id == "" || meta.FileTxnType == nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "id and file_txn_type are required")
+		return
+	}
+	if !meta.FileTxnType.Valid() {
+		writeDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("Unknown file_txn_type %d", *meta.FileTxnType))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A retried insert is acknowledged without recording it twice.
+	for _, f := range s.files {
+		if f.Id == meta.Id {
+			writeJSON(w, http.StatusOK, f)
+			return
+		}
+	}
+	s.files = append(s.files, meta)
+	writeJSON(w, http.StatusCreated, meta)
+}
+
+func (s *Server) handleInsertRun(w http.ResponseWriter, r *http.Request) {
+	var run models.RunSummary
+	if err := json.NewDecoder(r.Body).Decode(&run); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid run summary: "+err.Error())
+		return
+	}
+	if run.RunId == "" || run.Operation == "" {
+		writeDetail(w, http.StatusUnprocessableEntity, "run_id and operation are required")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.runs {
+		if existing.RunId == run.RunId {
+			writeJSON(w, http.StatusOK, existing)
+			return
+		}
+	}
+	s.runs = append(s.runs, run)
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (s *Server) handleQuota(w http.ResponseWriter, r *http.Request) {
+	var q models.UpdateUsageQuota
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid quota update: "+err.Error())
+		return
+	}
+	s.mu.Lock()
+	switch q.FileTxnType {
+	case models.TxnUpload: // upload adds to the current usage
+		*s.company.UsedQuota += q.UsedQuota
+	case models.TxnDelete: // delete reports the remaining usage
+		*s.company.UsedQuota = q.UsedQuota
+	default:
+		s.mu.Unlock()
+		writeDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("Unknown file_txn_type %d", q.FileTxnType))
+		return
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.Company())
+}
+
+func (s *Server) handleFolderSize(w http.ResponseWriter, r *http.Request) {
+	folder := s.folderPath(locTagOf(r.URL.Query().Get("loc_tag")))
+	size, err := dirSize(folder)
+	if err != nil {
+		writeDetail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, models.FolderInfoResponse{
+		FolderPath:        s.folderKey(locTagOf(r.URL.Query().Get("loc_tag"))),
+		TotalSize:         size,
+		TotalSizeReadable: fmt.Sprintf("%.2f MB", float64(size)/1024/1024),
+	})
+}
+
+func (s *Server) handleFolderList(w http.ResponseWriter, r *http.Request) {
+	locTag := locTagOf(r.URL.Query().Get("loc_tag"))
+	files, err := s.listObjects(s.folderPath(locTag))
+	if err != nil {
+		writeDetail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, models.FolderListResponse{FolderPath: s.folderKey(locTag), Files: files})
+}
+
+// listObjects lists the objects stored under dir, skipping in-progress
+// uploads.
+func (s *Server) listObjects(dir string) ([]models.RemoteFile, error) {
+	files := []models.RemoteFile{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.opts.StorageDir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		s.mu.Lock()
+		protected := s.protected[key]
+		s.mu.Unlock()
+		files = append(files, models.RemoteFile{
+			Key:          key,
+			FileName:     info.Name(),
+			Size:         info.Size(),
+			LastModified: &models.CustomTime{Time: info.ModTime().UTC().Truncate(time.Second)},
+			Protected:    protected,
+		})
+		return nil
+	})
+	return files, err
+}
+
+func (s *Server) handleDeleteFiles(w http.ResponseWriter, r *http.Request) {
+	var req models.FileDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid delete request: "+err.Error())
+		return
+	}
+	locTag := locTagOf(req.LocTag)
+	// Deleting the whole folder still spares protected objects.
+	stored, err := s.listObjects(s.folderPath(locTag))
+	if err != nil {
+		writeDetail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var keys []string
+	for _, f := range stored {
+		if !f.Protected {
+			keys = append(keys, f.Key)
+		}
+	}
+	files, ok := s.resolveKeys(w, s.folderKey(locTag)+"/", keys)
+	if !ok {
+		return
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			writeDetail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"detail": fmt.Sprintf("Deleted %d files in %s", len(keys), s.folderKey(locTag))})
+}
+
+// handleDeleteFileKeys deletes the given object keys. Unlike the folder
+// delete, an empty key list is an error rather than the whole folder.
+func (s *Server) handleDeleteFileKeys(w http.ResponseWriter, r *http.Request) {
+	var req models.FileDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid delete request: "+err.Error())
+		return
+	}
+	if len(req.FileKeys) == 0 {
+		writeDetail(w, http.StatusUnprocessableEntity, "file_keys is required")
+		return
+	}
+	locTag := locTagOf(req.LocTag)
+	files, ok := s.resolveKeys(w, s.folderKey(locTag)+"/", req.FileKeys)
+	if !ok {
+		return
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			writeDetail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"detail": fmt.Sprintf("Deleted %d files in %s", len(files), s.folderKey(locTag))})
+}
+
+// resolveKeys maps object keys onto StorageDir. Every key must lie under
+// prefix and must not be protected; otherwise an error response is written.
+func (s *Server) resolveKeys(w http.ResponseWriter, prefix string, keys []string) ([]string, bool) {
+	files := make([]string, 0, len(keys))
+	for _, key := range keys {
+		file, err := s.objectPath(key)
+		if err != nil || !strings.HasPrefix(path.Clean(key), prefix) {
+			writeDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("Key %q is not under %s", key, prefix))
+			return nil, false
+		}
+		s.mu.Lock()
+		protected := s.protected[path.Clean(key)]
+		s.mu.Unlock()
+		if protected {
+			writeDetail(w, http.StatusConflict, fmt.Sprintf("Key %q is protected", key))
+			return nil, false
+		}
+		files = append(files, file)
+	}
+	return files, true
+}
+
+// folderKey is the object key prefix for a loc tag, without a trailing slash.
+func (s *Server) folderKey(locTag string) string {
+	return s.company.CompanySlug + "/" + locTag
+}
+
+func (s *Server) folderPath(locTag string) string {
+	return filepath.Join(s.opts.StorageDir, filepath.FromSlash(s.folderKey(locTag)))
+}
+
+// objectPath maps an object key onto StorageDir, rejecting keys that escape it.
+func (s *Server) objectPath(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.opts.StorageDir, clean), nil
+}
+
+func locTagOf(v string) string {
+	if v == "" {
+		return defaultLocTag
+	}
+	return v
+}
+
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Like listObjects, leave out in-progress uploads.
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeDetail mirrors the backend's {"detail": "..."} error bodies.
+func writeDetail(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]string{"detail": detail})
+}
@@ -0,0 +1,78 @@
+package mockserver_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/api"
+	"shreshtasmg.in/sh_backups/mockserver"
+	"shreshtasmg.in/sh_backups/mockserver/mockservertest"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// writeFile writes content to name in a temporary directory and returns
+// its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRejectsWrongAPIKey(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	if _, err := api.NewAPIClient(b.URL, "wrong-key").FindCompanyByAPIKey("wrong-key"); err == nil {
+		t.Error("FindCompanyByAPIKey with a wrong key succeeded")
+	}
+	company, err := b.Client.FindCompanyByAPIKey(mockservertest.APIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if company.CompanyApiKey != mockservertest.APIKey || *company.TotalUsageQuota != 1<<30 || *company.UsedQuota != 0 {
+		t.Errorf("company = %+v, want the test key with a 1 GiB quota and nothing used", company)
+	}
+}
+
+// Uploads add to the used quota; deletes report what remains.
+func TestQuotaUpdate(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	steps := []struct {
+		update models.UpdateUsageQuota
+		want   int64
+	}{
+		{models.UpdateUsageQuota{UsedQuota: 100, FileTxnType: models.TxnUpload}, 100},
+		{models.UpdateUsageQuota{UsedQuota: 50, FileTxnType: models.TxnUpload}, 150},
+		{models.UpdateUsageQuota{UsedQuota: -30, FileTxnType: models.TxnUpload}, 120},
+		{models.UpdateUsageQuota{UsedQuota: 40, FileTxnType: models.TxnDelete}, 40},
+	}
+	for _, step := range steps {
+		if err := b.Client.UpdateCompanyQuota(&step.update); err != nil {
+			t.Fatalf("UpdateCompanyQuota(%+v): %v", step.update, err)
+		}
+		if got := *b.Company().UsedQuota; got != step.want {
+			t.Errorf("after %+v used quota = %d, want %d", step.update, got, step.want)
+		}
+	}
+
+	if err := b.Client.UpdateCompanyQuota(&models.UpdateUsageQuota{UsedQuota: 1, FileTxnType: models.TxnVerify}); err == nil {
+		t.Error("quota update with a verify transaction succeeded")
+	}
+	if got := *b.Company().UsedQuota; got != 40 {
+		t.Errorf("used quota = %d after a rejected update, want 40", got)
+	}
+}
+
+func TestSetTotalUsageQuota(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	b.SetTotalUsageQuota(10)
+	company, err := b.Client.FindCompanyByAPIKey(mockservertest.APIKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *company.TotalUsageQuota != 10 {
+		t.Errorf("total quota = %d, want 10", *company.TotalUsageQuota)
+	}
+}
@@ -0,0 +1,157 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"shreshtasmg.in/sh_backups/models"
+)
+
+// trashFolderKey is the trash prefix for a loc tag, without a trailing
+// slash. Trashed objects keep the time they were trashed as their
+// modification time.
+func (s *Server) trashFolderKey(locTag string) string {
+	return s.company.CompanySlug + "/.trash/" + locTag
+}
+
+func (s *Server) trashKey(locTag, name string) string {
+	return s.trashFolderKey(locTag) + "/" + name
+}
+
+// moveObject renames the object at file to key, stamping it with the
+// current time.
+func (s *Server) moveObject(file, key string) error {
+	dest, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(file, dest); err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(dest, now, now)
+}
+
+func (s *Server) handleProtect(w http.ResponseWriter, r *http.Request) {
+	var req models.ProtectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid protect request: "+err.Error())
+		return
+	}
+	prefix := s.folderKey(locTagOf(req.LocTag)) + "/"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range req.FileKeys {
+		key = path.Clean(key)
+		file, err := s.objectPath(key)
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			writeDetail(w, http.StatusUnprocessableEntity, fmt.Sprintf("Key %q is not under %s", key, prefix))
+			return
+		}
+		if _, err := os.Stat(file); err != nil {
+			writeDetail(w, http.StatusNotFound, fmt.Sprintf("Key %q does not exist", key))
+			return
+		}
+	}
+	for _, key := range req.FileKeys {
+		if req.Protected {
+			s.protected[path.Clean(key)] = true
+		} else {
+			delete(s.protected, path.Clean(key))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"detail": fmt.Sprintf("Updated %d files", len(req.FileKeys))})
+}
+
+func (s *Server) handleTrashList(w http.ResponseWriter, r *http.Request) {
+	locTag := locTagOf(r.URL.Query().Get("loc_tag"))
+	files, err := s.listObjects(filepath.Join(s.opts.StorageDir, filepath.FromSlash(s.trashFolderKey(locTag))))
+	if err != nil {
+		writeDetail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, models.FolderListResponse{FolderPath: s.trashFolderKey(locTag), Files: files})
+}
+
+// handleTrashFiles moves the given object keys to the trash and lists the
+// keys it moved.
+func (s *Server) handleTrashFiles(w http.ResponseWriter, r *http.Request) {
+	var req models.FileDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid trash request: "+err.Error())
+		return
+	}
+	if len(req.FileKeys) == 0 {
+		writeDetail(w, http.StatusUnprocessableEntity, "file_keys is required")
+		return
+	}
+	locTag := locTagOf(req.LocTag)
+	files, ok := s.resolveKeys(w, s.folderKey(locTag)+"/", req.FileKeys)
+	if !ok {
+		return
+	}
+	trashed := models.TrashResponse{Trashed: []string{}}
+	for i, file := range files {
+		if err := s.moveObject(file, s.trashKey(locTag, path.Base(req.FileKeys[i]))); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			writeDetail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		trashed.Trashed = append(trashed.Trashed, req.FileKeys[i])
+	}
+	writeJSON(w, http.StatusOK, trashed)
+}
+
+func (s *Server) handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	var req models.FileDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid restore request: "+err.Error())
+		return
+	}
+	locTag := locTagOf(req.LocTag)
+	files, ok := s.resolveKeys(w, s.trashFolderKey(locTag)+"/", req.FileKeys)
+	if !ok {
+		return
+	}
+	for i, file := range files {
+		if err := s.moveObject(file, s.folderKey(locTag)+"/"+path.Base(req.FileKeys[i])); err != nil {
+			status := http.StatusInternalServerError
+			if os.IsNotExist(err) {
+				status = http.StatusNotFound
+			}
+			writeDetail(w, status, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"detail": fmt.Sprintf("Restored %d files to %s", len(files), s.folderKey(locTag))})
+}
+
+func (s *Server) handleTrashPurge(w http.ResponseWriter, r *http.Request) {
+	var req models.FileDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, "Invalid purge request: "+err.Error())
+		return
+	}
+	files, ok := s.resolveKeys(w, s.trashFolderKey(locTagOf(req.LocTag))+"/", req.FileKeys)
+	if !ok {
+		return
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			writeDetail(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"detail": fmt.Sprintf("Purged %d files", len(files))})
+}
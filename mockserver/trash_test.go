@@ -0,0 +1,122 @@
+package mockserver_test
+
+import (
+	"reflect"
+	"testing"
+
+	"shreshtasmg.in/sh_backups/mockserver"
+	"shreshtasmg.in/sh_backups/mockserver/mockservertest"
+	"shreshtasmg.in/sh_backups/models"
+)
+
+const folder = "TallyBackups"
+
+// stored uploads a file per name and returns their keys.
+func stored(t *testing.T, b *mockservertest.Backend, names ...string) []string {
+	t.Helper()
+	var keys []string
+	for _, name := range names {
+		if err := b.Client.UploadFile(mockservertest.APIKey, writeFile(t, name, "contents of "+name)); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, b.Company().CompanySlug+"/"+folder+"/"+name)
+	}
+	return keys
+}
+
+// listed returns the names of the files listed by list, failing the test
+// if listing fails.
+func listed(t *testing.T, list func(apiKey, folderPrefix string) ([]models.RemoteFile, error)) []string {
+	t.Helper()
+	files, err := list(mockservertest.APIKey, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.FileName)
+	}
+	return names
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	c := b.Client
+	keys := stored(t, b, "a.zip", "b.zip")
+
+	if err := c.TrashFiles(mockservertest.APIKey, folder, keys[:1]); err != nil {
+		t.Fatalf("TrashFiles: %v", err)
+	}
+	if got := listed(t, c.ListFiles); !reflect.DeepEqual(got, []string{"b.zip"}) {
+		t.Errorf("folder = %v after trashing a.zip, want [b.zip]", got)
+	}
+	if got := listed(t, c.ListTrash); !reflect.DeepEqual(got, []string{"a.zip"}) {
+		t.Fatalf("trash = %v, want [a.zip]", got)
+	}
+	trashed, err := c.ListTrash(mockservertest.APIKey, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RestoreFiles(mockservertest.APIKey, folder, []string{trashed[0].Key}); err != nil {
+		t.Fatalf("RestoreFiles: %v", err)
+	}
+	if got := listed(t, c.ListFiles); !reflect.DeepEqual(got, []string{"a.zip", "b.zip"}) {
+		t.Errorf("folder = %v after restore, want both files", got)
+	}
+
+	if err := c.TrashFiles(mockservertest.APIKey, folder, keys); err != nil {
+		t.Fatal(err)
+	}
+	trashed, err = c.ListTrash(mockservertest.APIKey, folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PurgeTrash(mockservertest.APIKey, folder, []string{trashed[0].Key, trashed[1].Key}); err != nil {
+		t.Fatalf("PurgeTrash: %v", err)
+	}
+	if got := listed(t, c.ListTrash); len(got) != 0 {
+		t.Errorf("trash = %v after purging, want it empty", got)
+	}
+	if got := listed(t, c.ListFiles); len(got) != 0 {
+		t.Errorf("folder = %v after purging, want it empty", got)
+	}
+}
+
+func TestTrashRefusesProtected(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	keys := stored(t, b, "a.zip")
+	if err := b.Client.SetProtected(mockservertest.APIKey, folder, keys, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Client.TrashFiles(mockservertest.APIKey, folder, keys); err == nil {
+		t.Error("trashing a protected file succeeded")
+	}
+	if err := b.Client.DeleteFileKeys(mockservertest.APIKey, folder, keys); err == nil {
+		t.Error("deleting a protected file succeeded")
+	}
+	// Emptying the folder spares it.
+	if err := b.Client.DeleteFiles(mockservertest.APIKey, folder); err != nil {
+		t.Fatal(err)
+	}
+	if got := listed(t, b.Client.ListFiles); !reflect.DeepEqual(got, []string{"a.zip"}) {
+		t.Errorf("folder = %v, want the protected file kept", got)
+	}
+}
+
+func TestTrashRejectsKeysOutsideFolder(t *testing.T) {
+	b := mockservertest.New(t, mockserver.Options{})
+	stored(t, b, "a.zip")
+	for _, key := range []string{
+		"other-company/" + folder + "/a.zip",
+		b.Company().CompanySlug + "/" + folder + "/../../a.zip",
+		"",
+	} {
+		if err := b.Client.TrashFiles(mockservertest.APIKey, folder, []string{key}); err == nil {
+			t.Errorf("TrashFiles(%q) succeeded", key)
+		}
+	}
+	if err := b.Client.TrashFiles(mockservertest.APIKey, folder, nil); err == nil {
+		t.Error("TrashFiles with no keys succeeded")
+	}
+}
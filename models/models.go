@@ -2,6 +2,7 @@ package models
 
 import (
 	"bytes"
+	"fmt"
 	"time"
 )
 
@@ -27,6 +28,13 @@ func (ct *CustomTime) UnmarshalJSON(b []byte) (err error) {
 	return
 }
 
+func (ct CustomTime) MarshalJSON() ([]byte, error) {
+	if ct.IsZero() {
+		return []byte("null"), nil
+	}
+	return []byte(`"` + ct.Format(CustomTimeFormat) + `"`), nil
+}
+
 type Company struct {
 	Id              string      `json:"id"`
 	CreatedAt       *CustomTime `json:"created_at"`
@@ -40,26 +48,118 @@ type Company struct {
 	BaseURL         string      `json:"api_base_url"`
 }
 
+// FileTxnType is the kind of transaction a file metadata record or quota
+// update describes. It is sent as a number; the values are stored by the
+// backend and must never change.
+type FileTxnType int16
+
+const (
+	TxnUpload FileTxnType = 1
+	// TxnDelete also marks a quota update that sets the used quota rather
+	// than adding to it.
+	TxnDelete  FileTxnType = 2
+	TxnRestore FileTxnType = 3
+	// TxnSkipped is a local backup that was not uploaded, such as a corrupt
+	// archive.
+	TxnSkipped        FileTxnType = 4
+	TxnVerify         FileTxnType = 5
+	TxnFailedUpload   FileTxnType = 6
+	TxnQuotaReconcile FileTxnType = 7
+	// TxnMirror is a copy to the local NAS mirror. Nothing is stored in S3,
+	// so its record has no file key.
+	TxnMirror FileTxnType = 8
+)
+
+var txnTypeNames = map[FileTxnType]string{
+	TxnUpload:         "upload",
+	TxnDelete:         "delete",
+	TxnRestore:        "restore",
+	TxnSkipped:        "skipped",
+	TxnVerify:         "verify",
+	TxnFailedUpload:   "failed-upload",
+	TxnQuotaReconcile: "quota-reconcile",
+	TxnMirror:         "mirror",
+}
+
+func (t FileTxnType) String() string {
+	if name, ok := txnTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("FileTxnType(%d)", int16(t))
+}
+
+// Valid reports whether t is a known transaction type.
+func (t FileTxnType) Valid() bool {
+	_, ok := txnTypeNames[t]
+	return ok
+}
+
+// Ptr returns a pointer to a copy of t, for FileMetadata.FileTxnType.
+func (t FileTxnType) Ptr() *FileTxnType {
+	return &t
+}
+
+// FileMetadata records one file transaction with the backend. The fields
+// after FileTxnMeta are omitted when empty, so records without them look
+// as they always have.
 type FileMetadata struct {
-	Id          string `json:"id"`
-	CreatedAt   string `json:"created_at"`
-	FileName    string `json:"file_name"`
-	FileSize    *int64 `json:"file_size"`
-	FileKey     string `json:"file_key"`
-	CompanyId   string `json:"company_id"`
-	FileTxnType *int16 `json:"file_txn_type"`
-	FileTxnMeta string `json:"file_txn_meta"`
+	Id          string       `json:"id"`
+	CreatedAt   string       `json:"created_at"`
+	FileName    string       `json:"file_name"`
+	FileSize    *int64       `json:"file_size"`
+	FileKey     string       `json:"file_key"`
+	CompanyId   string       `json:"company_id"`
+	FileTxnType *FileTxnType `json:"file_txn_type"`
+	FileTxnMeta string       `json:"file_txn_meta"`
+	// Checksum is the file's hex SHA-256, when it is known.
+	Checksum   string `json:"checksum,omitempty"`
+	Host       string `json:"host,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	RunId      string `json:"run_id,omitempty"`
+	// Error describes why a failed transaction failed.
+	Error string `json:"error,omitempty"`
 }
 
+// RunSummary records what one run of the tool did: an operation over one
+// or more jobs. It is appended to the local history file and optionally
+// posted to the backend.
+type RunSummary struct {
+	RunId           string   `json:"run_id"`
+	CompanyId       string   `json:"company_id,omitempty"`
+	Host            string   `json:"host,omitempty"`
+	Operation       string   `json:"operation"`
+	Jobs            []string `json:"jobs"`
+	StartedAt       string   `json:"started_at"`
+	FinishedAt      string   `json:"finished_at"`
+	DurationMs      int64    `json:"duration_ms"`
+	FilesConsidered int      `json:"files_considered"`
+	FilesUploaded   int      `json:"files_uploaded"`
+	FilesSkipped    int      `json:"files_skipped"`
+	FilesDeleted    int      `json:"files_deleted"`
+	BytesUploaded   int64    `json:"bytes_uploaded"`
+	BytesDeleted    int64    `json:"bytes_deleted"`
+	FilesMirrored   int      `json:"files_mirrored"`
+	BytesMirrored   int64    `json:"bytes_mirrored"`
+	FilesVerified   int      `json:"files_verified"`
+	Errors          []string `json:"errors,omitempty"`
+	// ExitStatus is the process exit code: 0 on success, 1 on failure.
+	ExitStatus int `json:"exit_status"`
+}
+
+// UpdateUsageQuota changes the company's used quota: TxnUpload adds
+// UsedQuota to it and TxnDelete sets it to UsedQuota.
 type UpdateUsageQuota struct {
-	UsedQuota   int64 `json:"used_quota"`
-	FileTxnType int16 `json:"file_txn_type"`
+	UsedQuota   int64       `json:"used_quota"`
+	FileTxnType FileTxnType `json:"file_txn_type"`
 }
 
 type PresignUploadRequest struct {
 	FileName    string `json:"file_name"`
 	ContentSize int64  `json:"content_size"`
 	LocTag      string `json:"loc_tag"`
+	// Rotate marks an upload made by a rotation, which may take usage past
+	// the quota until the rotation deletes the backups it replaces.
+	Rotate bool `json:"rotate,omitempty"`
 }
 
 type PresignedUploadResponse struct {
@@ -69,6 +169,15 @@ type PresignedUploadResponse struct {
 	Fields map[string]string `json:"fields"`
 }
 
+type PresignDownloadRequest struct {
+	// FileKey is the full object key, as returned in RemoteFile.Key.
+	FileKey string `json:"file_key"`
+}
+
+type PresignedDownloadResponse struct {
+	URL string `json:"url"`
+}
+
 type UploadRequest struct {
 	Key            string        `form:"key"`
 	XAmzAlgorithm  string        `form:"x-amz-algorithm"`
@@ -82,6 +191,38 @@ type UploadRequest struct {
 
 type FileDeleteRequest struct {
 	LocTag string `json:"loc_tag"`
+	// FileKeys are the object keys under LocTag to act on. The folder
+	// delete endpoint ignores them and deletes the whole folder.
+	FileKeys []string `json:"file_keys"`
+}
+
+// TrashResponse lists the object keys the backend moved to the trash.
+type TrashResponse struct {
+	Trashed []string `json:"trashed"`
+}
+
+// ProtectRequest sets or clears the protected (legal hold) flag of backups;
+// protected backups cannot be deleted.
+type ProtectRequest struct {
+	LocTag    string   `json:"loc_tag"`
+	FileKeys  []string `json:"file_keys"`
+	Protected bool     `json:"protected"`
+}
+
+// RemoteFile is one stored backup in a folder listing.
+type RemoteFile struct {
+	// Key is the full object key, e.g. "acme/TallyBackups/Tallybackupason01102026.zip".
+	Key          string      `json:"key"`
+	FileName     string      `json:"file_name"`
+	Size         int64       `json:"size"`
+	LastModified *CustomTime `json:"last_modified"`
+	// Protected backups are under legal hold and must not be deleted.
+	Protected bool `json:"protected,omitempty"`
+}
+
+type FolderListResponse struct {
+	FolderPath string       `json:"folder_path"`
+	Files      []RemoteFile `json:"files"`
 }
 
 type FolderInfoResponse struct {
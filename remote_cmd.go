@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"shreshtasmg.in/sh_backups/backup"
+	"shreshtasmg.in/sh_backups/config"
+	"shreshtasmg.in/sh_backups/models"
+	"shreshtasmg.in/sh_backups/runid"
+)
+
+// remotePipeline parses the config flags of a remote command and returns
+// the pipeline for the selected job together with the remaining arguments.
+func remotePipeline(name string, args []string, extra func(fs *flag.FlagSet)) (*backup.Pipeline, []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	overrides := configFlags(fs)
+	jobName := fs.String("job", "", "job whose backups to use (default: the first job)")
+	if extra != nil {
+		extra(fs)
+	}
+	_ = fs.Parse(args)
+
+	runid.StartRun()
+	cfg := loadConfig(*overrides)
+	job := selectJobs(cfg, *jobName)[0]
+	apiClient := newAPIClient(cfg)
+	company, err := apiClient.FindCompanyByAPIKey(cfg.APIKey)
+	if err != nil {
+		exitErr("Failed to fetch company", err)
+	}
+	return newPipeline(cfg, apiClient, company, job), fs.Args()
+}
+
+// runList prints the remote backups:
+//
+//	sh-backups list
+func runList(args []string) {
+	p, _ := remotePipeline("list", args, nil)
+	files, err := p.Backend.ListFiles(p.Company.CompanyApiKey, p.LocTag)
+	if err != nil {
+		exitErr("Failed to list remote backups", err)
+	}
+	printRemoteFiles(files)
+	exit(nil)
+}
+
+// runProtect sets or clears the protected flag of remote backups, which
+// keeps them from being deleted by any operation:
+//
+//	sh-backups protect Tallybackupason01012026.zip
+//	sh-backups unprotect Tallybackupason01012026.zip
+func runProtect(args []string, protected bool) {
+	name := "protect"
+	if !protected {
+		name = "unprotect"
+	}
+	p, names := remotePipeline(name, args, nil)
+	if len(names) == 0 {
+		fmt.Fprintf(os.Stderr, "usage: sh-backups %s [flags] <backup>...\n", name)
+		os.Exit(2)
+	}
+	exit(p.Protect(names, protected))
+}
+
+// runTrash manages backups moved to the trash by deletes with
+// safeguards.trash enabled:
+//
+//	sh-backups trash list
+//	sh-backups trash restore Tallybackupason01012026.zip
+//	sh-backups trash purge [-all | <backup>...]
+func runTrash(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: sh-backups trash <list|restore|purge> [flags]")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "list":
+		p, _ := remotePipeline("trash list", args[1:], nil)
+		files, err := p.Backend.ListTrash(p.Company.CompanyApiKey, p.LocTag)
+		if err != nil {
+			exitErr("Failed to list trash", err)
+		}
+		printRemoteFiles(files)
+		exit(nil)
+	case "restore":
+		p, names := remotePipeline("trash restore", args[1:], nil)
+		if len(names) == 0 {
+			fmt.Fprintln(os.Stderr, "usage: sh-backups trash restore [flags] <backup>...")
+			os.Exit(2)
+		}
+		exit(p.Restore(names))
+	case "purge":
+		var all bool
+		p, names := remotePipeline("trash purge", args[1:], func(fs *flag.FlagSet) {
+			fs.BoolVar(&all, "all", false, "purge every backup in the trash")
+		})
+		if all == (len(names) > 0) {
+			fmt.Fprintln(os.Stderr, "usage: sh-backups trash purge [flags] -all | <backup>...")
+			os.Exit(2)
+		}
+		exit(p.PurgeTrash(names))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown trash command %q\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// runDeleteSelected deletes chosen remote backups, by name, by age or by a
+// range of backup dates:
+//
+//	sh-backups delete Tallybackupason01012026.zip
+//	sh-backups delete -older-than 90d
+//	sh-backups delete -from 2026-01-01 -to 2026-03-31
+func runDeleteSelected(args []string) {
+	var olderThan, before, from, to string
+	p, names := remotePipeline("delete", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&olderThan, "older-than", "", "delete backups older than this, e.g. 90d")
+		fs.StringVar(&before, "before", "", "delete backups dated before this day (YYYY-MM-DD)")
+		fs.StringVar(&from, "from", "", "delete backups dated on or after this day (YYYY-MM-DD)")
+		fs.StringVar(&to, "to", "", "delete backups dated on or before this day (YYYY-MM-DD)")
+		fs.BoolVar(&assumeYes, "yes", false, "do not ask for confirmation")
+	})
+	sel := backup.Selection{Names: names}
+	var err error
+	if sel.Before, err = parseDay(before); err != nil {
+		exitErr("Invalid -before", err)
+	}
+	if sel.From, err = parseDay(from); err != nil {
+		exitErr("Invalid -from", err)
+	}
+	if sel.To, err = parseDay(to); err != nil {
+		exitErr("Invalid -to", err)
+	}
+	age, err := config.ParseDuration(olderThan)
+	if err != nil {
+		exitErr("Invalid -older-than", err)
+	}
+	if age > 0 {
+		cutoff := time.Now().Add(-age)
+		if sel.Before.IsZero() || cutoff.Before(sel.Before) {
+			sel.Before = cutoff
+		}
+	}
+	if sel.IsZero() {
+		fmt.Fprintln(os.Stderr, "usage: sh-backups delete [flags] [-older-than AGE] [-before DAY] [-from DAY] [-to DAY] [<backup>...]")
+		os.Exit(2)
+	}
+	exit(p.DeleteSelected(sel))
+}
+
+// parseDay parses a YYYY-MM-DD day in UTC, the zone backup dates are read
+// in, so a day bound lines up with the dates in backup names.
+func parseDay(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// runReconcile compares the company's used quota and the local ledger with
+// the remote folder and corrects them:
+//
+//	sh-backups reconcile
+//	sh-backups reconcile -dry-run
+func runReconcile(args []string) {
+	var dryRun bool
+	p, _ := remotePipeline("reconcile", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "report discrepancies without correcting them")
+	})
+	r, err := p.Reconcile(!dryRun)
+	if err != nil {
+		exit(err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Remote folder\t%d\n", r.Remote)
+	fmt.Fprintf(tw, "Used quota\t%d\t%s\n", r.Recorded, difference(r.Recorded, r.Remote))
+	if r.HasLedger {
+		fmt.Fprintf(tw, "Local ledger\t%d\t%s\n", r.Ledger, difference(r.Ledger, r.Remote))
+	}
+	_ = tw.Flush()
+	switch {
+	case r.InSync():
+		fmt.Println("In sync.")
+	case r.Corrected:
+		fmt.Println("Corrected to the remote folder size.")
+	default:
+		fmt.Println("Out of sync; run without -dry-run to correct.")
+	}
+	exit(nil)
+}
+
+// runRestore downloads a remote backup, reassembling a chunked one from its
+// chunks, and checks it before it is put in place:
+//
+//	sh-backups restore Tallybackupason01012026.zip
+//	sh-backups restore -out D:\Restore Tallybackupason01012026.zip
+func runRestore(args []string) {
+	var out string
+	p, names := remotePipeline("restore", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&out, "out", ".", "directory to restore into")
+	})
+	if len(names) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sh-backups restore [flags] [-out DIR] <backup>")
+		os.Exit(2)
+	}
+	path, err := p.Fetch(names[0], out)
+	if err != nil {
+		exit(err)
+	}
+	fmt.Println(path)
+	exit(nil)
+}
+
+// runVerify test-restores the latest remote backup of each job, or a random
+// sample of them, and records the result like any other run:
+//
+//	sh-backups verify
+//	sh-backups verify -sample 3 -job tally
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	overrides := configFlags(fs)
+	jobName := fs.String("job", "", "verify only the named job (default: every job)")
+	sample := fs.Int("sample", -1, "verify this many backups picked at random instead of the latest (default: verify.sample)")
+	_ = fs.Parse(args)
+
+	runid.StartRun()
+	cfg := loadConfig(*overrides)
+	if !cfg.License.HasFeature("verify") {
+		exitErr("Cannot verify backups", errors.New("the license does not include the verify feature"))
+	}
+	if *sample >= 0 {
+		cfg.Verify.Sample = *sample
+	}
+	jobs := selectJobs(cfg, *jobName)
+	exit(runJobs(cfg, newAPIClient(cfg), jobs, "verify"))
+}
+
+// runManifest prints the manifest stored next to a remote backup:
+//
+//	sh-backups manifest Tallybackupason01012026.zip
+func runManifest(args []string) {
+	p, names := remotePipeline("manifest", args, nil)
+	if len(names) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sh-backups manifest [flags] <backup>")
+		os.Exit(2)
+	}
+	m, err := p.ReadManifest(names[0])
+	if err != nil {
+		exit(err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(m)
+	exit(nil)
+}
+
+func difference(value, remote int64) string {
+	if value == remote {
+		return "ok"
+	}
+	return fmt.Sprintf("%+d", value-remote)
+}
+
+// printRemoteFiles prints the backups in files; their manifests are left
+// out.
+func printRemoteFiles(all []models.RemoteFile) {
+	var files []models.RemoteFile
+	for _, f := range all {
+		if !backup.IsManifest(f.FileName) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("No backups.")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tSTORED\tPROTECTED")
+	for _, f := range files {
+		stored := "-"
+		if f.LastModified != nil {
+			stored = f.LastModified.Time.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%t\n", f.FileName, f.Size, stored, f.Protected)
+	}
+	_ = tw.Flush()
+}
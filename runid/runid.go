@@ -0,0 +1,92 @@
+// Package runid tracks the correlation IDs of the current run and of the
+// operation in progress. They are added to every log record, sent to the
+// backend as X-Request-Id and W3C traceparent headers and stored with file
+// metadata, so a line in a customer's activity.log can be matched to the
+// server's logs.
+package runid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// IDs identifies the current run and operation.
+type IDs struct {
+	// Run is 32 hex digits shared by every request of a run; it doubles as
+	// the W3C trace ID.
+	Run string
+	// Op is 16 hex digits identifying the current operation, or "" outside
+	// an operation.
+	Op string
+	// span is the traceparent parent ID: Op, or the run's own span between
+	// operations.
+	span string
+}
+
+var (
+	mu      sync.Mutex
+	current IDs
+	runSpan string
+)
+
+// StartRun begins a new run and returns its ID. Runs never overlap, so the
+// IDs are process-wide.
+func StartRun() string {
+	mu.Lock()
+	defer mu.Unlock()
+	runSpan = randomHex(8)
+	current = IDs{Run: randomHex(16), span: runSpan}
+	return current.Run
+}
+
+// EndRun clears the IDs, e.g. between the scheduled runs of the daemon.
+func EndRun() {
+	mu.Lock()
+	defer mu.Unlock()
+	current, runSpan = IDs{}, ""
+}
+
+// StartOperation gives the next operation of the current run its own ID.
+func StartOperation() string {
+	mu.Lock()
+	defer mu.Unlock()
+	current.Op = randomHex(8)
+	current.span = current.Op
+	return current.Op
+}
+
+// EndOperation returns to run-level IDs.
+func EndOperation() {
+	mu.Lock()
+	defer mu.Unlock()
+	current.Op, current.span = "", runSpan
+}
+
+// Current returns the IDs in effect; Run is "" before StartRun.
+func Current() IDs {
+	mu.Lock()
+	defer mu.Unlock()
+	return current
+}
+
+// Traceparent formats the IDs as a W3C trace context header value.
+func (ids IDs) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", ids.Run, ids.span)
+}
+
+// String formats the IDs for FileMetadata.FileTxnMeta, e.g.
+// "run_id=4bf9... op_id=00f0...".
+func (ids IDs) String() string {
+	if ids.Op == "" {
+		return "run_id=" + ids.Run
+	}
+	return fmt.Sprintf("run_id=%s op_id=%s", ids.Run, ids.Op)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package runid
+
+import (
+	"regexp"
+	"testing"
+)
+
+var traceparent = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+
+func TestRunAndOperationIDs(t *testing.T) {
+	t.Cleanup(EndRun)
+	if ids := Current(); ids.Run != "" || ids.Op != "" {
+		t.Fatalf("IDs before StartRun = %+v, want none", ids)
+	}
+
+	run := StartRun()
+	if len(run) != 32 {
+		t.Fatalf("run ID %q is not 32 hex digits", run)
+	}
+	between := Current().Traceparent()
+	if !traceparent.MatchString(between) || between[3:35] != run {
+		t.Errorf("traceparent = %q, want the run ID as trace ID", between)
+	}
+	if got := Current().String(); got != "run_id="+run {
+		t.Errorf("String = %q outside an operation", got)
+	}
+
+	op := StartOperation()
+	ids := Current()
+	if len(op) != 16 || ids.Run != run || ids.Op != op {
+		t.Fatalf("IDs in an operation = %+v, want run %s with op %s", ids, run, op)
+	}
+	if got, want := ids.Traceparent(), "00-"+run+"-"+op+"-01"; got != want {
+		t.Errorf("traceparent = %q in an operation, want %q", got, want)
+	}
+	if got, want := ids.String(), "run_id="+run+" op_id="+op; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+
+	EndOperation()
+	if ids := Current(); ids.Op != "" || ids.Traceparent() != between {
+		t.Errorf("after EndOperation traceparent = %q, want the run's %q", ids.Traceparent(), between)
+	}
+	if next := StartOperation(); next == op {
+		t.Error("two operations got the same ID")
+	}
+
+	EndRun()
+	if ids := Current(); ids.Run != "" || ids.Op != "" {
+		t.Errorf("IDs after EndRun = %+v, want none", ids)
+	}
+	if StartRun() == run {
+		t.Error("two runs got the same ID")
+	}
+}
@@ -0,0 +1,19 @@
+package storage
+
+import "shreshtasmg.in/sh_backups/api"
+
+// Backend uploads through the backend: it asks for a presigned S3 POST and
+// sends the file to it. The backend also keeps the quota and file metadata,
+// so it is always a job's primary destination.
+type Backend struct {
+	Backend api.Backend
+	APIKey  string
+}
+
+func (b *Backend) Name() string {
+	return "backend"
+}
+
+func (b *Backend) Put(localPath string) error {
+	return b.Backend.UploadFile(b.APIKey, localPath)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Filesystem copies backups into a directory, such as a NAS share mounted
+// on this machine.
+type Filesystem struct {
+	DestName string
+	Dir      string
+}
+
+func (f *Filesystem) Name() string {
+	return f.DestName
+}
+
+func (f *Filesystem) Put(localPath string) error {
+	_, err := f.Copy(localPath)
+	return err
+}
+
+// Copy copies localPath into Dir and returns its hex SHA-256. The copy is
+// written to a temporary file, read back and compared with the source's
+// checksum, and only then renamed into place, so a partial or damaged copy
+// never replaces a good one.
+func (f *Filesystem) Copy(localPath string) (string, error) {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("cannot create %s: %w", f.Dir, err)
+	}
+	in, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(f.Dir, "."+filepath.Base(localPath)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), in)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	sum := h.Sum(nil)
+	if err == nil {
+		err = verifyChecksum(tmp.Name(), sum)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(f.Dir, filepath.Base(localPath)))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("cannot copy to %s: %w", f.Dir, err)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// verifyChecksum reads path back and compares its SHA-256 with sum.
+func verifyChecksum(path string, sum []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("checksum of the copy does not match the source")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// Package storage holds the destinations a backup can be uploaded to: the
+// backend-mediated S3 upload, S3-compatible storage reached directly with
+// its own credentials, and a local or network filesystem path.
+package storage
+
+import (
+	"fmt"
+	"net/http"
+
+	"shreshtasmg.in/sh_backups/config"
+)
+
+// Destination stores a local backup under its file name.
+type Destination interface {
+	// Name identifies the destination in logs and metadata.
+	Name() string
+	// Put uploads the file at localPath, replacing any stored file of the
+	// same name.
+	Put(localPath string) error
+}
+
+// New builds the destination described by dc. client is used for HTTP
+// destinations and may be nil.
+func New(dc config.DestinationConfig, client *http.Client) (Destination, error) {
+	switch dc.Type {
+	case config.DestinationS3:
+		if client == nil {
+			client = http.DefaultClient
+		}
+		return &S3{
+			DestName:        dc.Name,
+			Endpoint:        dc.Endpoint,
+			Region:          dc.Region,
+			Bucket:          dc.Bucket,
+			Prefix:          dc.Prefix,
+			AccessKeyID:     dc.AccessKeyID,
+			SecretAccessKey: dc.SecretAccessKey,
+			PathStyle:       dc.PathStyle,
+			Client:          client,
+		}, nil
+	case config.DestinationFilesystem:
+		return &Filesystem{DestName: dc.Name, Dir: dc.Path}, nil
+	default:
+		return nil, fmt.Errorf("destination %q: unknown type %q", dc.Name, dc.Type)
+	}
+}
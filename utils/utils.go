@@ -4,14 +4,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
-func PtrInt16(v int16) *int16 {
-	return &v
-}
-
 func PtrInt64(v int64) *int64 {
 	return &v
 }
@@ -31,14 +28,27 @@ func Slugify(input string) string {
 	return slug
 }
 
+var tallyBackupName = regexp.MustCompile(`^Tallybackupason(\d{2})(\d{2})(\d{4})\.zip$`)
+
+// BackupDate returns the date encoded in a Tallybackupason<DDMMYYYY>.zip
+// file name.
+func BackupDate(name string) (time.Time, bool) {
+	matches := tallyBackupName.FindStringSubmatch(name)
+	if len(matches) != 4 {
+		return time.Time{}, false
+	}
+	date, err := time.Parse("02012006", matches[1]+matches[2]+matches[3])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
 func FindZipFileWithPatternAndLatestDate(folder string) (string, int64, error) {
 	var match string
 	var size int64
 	var latestDate time.Time
 
-	// Compile regex for Tallybackupason files
-	regex := regexp.MustCompile(`^Tallybackupason(\d{2})(\d{2})(\d{4})\.zip$`)
-
 	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
 			return nil
@@ -46,11 +56,8 @@ func FindZipFileWithPatternAndLatestDate(folder string) (string, int64, error) {
 		name := info.Name()
 		if strings.HasSuffix(name, ".zip") {
 			// Check for Tallybackupason pattern
-			matches := regex.FindStringSubmatch(name)
-			if len(matches) > 3 {
-				dateStr := matches[1] + matches[2] + matches[3]
-				fileDate, err := time.Parse("02012006", dateStr)
-				if err == nil && fileDate.After(latestDate) {
+			if fileDate, ok := BackupDate(name); ok {
+				if fileDate.After(latestDate) {
 					match = path
 					size = info.Size()
 					latestDate = fileDate
@@ -72,3 +79,27 @@ func FindZipFileWithPatternAndLatestDate(folder string) (string, int64, error) {
 	}
 	return match, size, nil
 }
+
+// FindZipFilesNewestFirst returns every .zip file under folder: Tally
+// backups newest first by the date in their name, then any other archives.
+func FindZipFilesNewestFirst(folder string) ([]string, error) {
+	var dated, other []string
+	dates := map[string]time.Time{}
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(info.Name(), ".zip") {
+			return nil
+		}
+		if fileDate, ok := BackupDate(info.Name()); ok {
+			dated = append(dated, path)
+			dates[path] = fileDate
+		} else {
+			other = append(other, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(dated, func(i, j int) bool { return dates[dated[i]].After(dates[dated[j]]) })
+	return append(dated, other...), nil
+}